@@ -0,0 +1,138 @@
+package imd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BlockDevice exposes the sectors of a File as a seekable byte stream laid
+// out like RawImage, for filesystem code written against block devices.
+// Unavailable sectors read as zeros. Writes are buffered per sector until
+// Flush stores them in the File. The File's tracks must not be changed while
+// a BlockDevice is in use.
+type BlockDevice struct {
+	file    *File
+	sectors []blockSector
+	size    int
+	pos     int64
+	dirty   map[int][]byte
+}
+
+// blockSector locates a sector of a BlockDevice in its File.
+type blockSector struct {
+	track, index int
+}
+
+// AsBlockDevice returns a BlockDevice over f. Every sector of f must be
+// bytesPerSector long.
+func (f *File) AsBlockDevice(bytesPerSector int) (*BlockDevice, error) {
+	d := &BlockDevice{file: f, size: bytesPerSector, dirty: map[int][]byte{}}
+	for ti, t := range f.Tracks {
+		if t.Unformatted {
+			continue
+		}
+		if size := SectorSizeBytes(t.SectorSize); size != bytesPerSector {
+			return nil, fmt.Errorf("track %d/%d has %d-byte sectors, not %d", t.Cylinder, t.headNumber(), size, bytesPerSector)
+		}
+		for _, i := range t.logicalOrder() {
+			d.sectors = append(d.sectors, blockSector{ti, i})
+		}
+	}
+	return d, nil
+}
+
+// Size returns the length of the device in bytes.
+func (d *BlockDevice) Size() int64 {
+	return int64(len(d.sectors)) * int64(d.size)
+}
+
+// sector returns the current contents of sector n, or nil if it is
+// unavailable and has not been written.
+func (d *BlockDevice) sector(n int) []byte {
+	if data, ok := d.dirty[n]; ok {
+		return data
+	}
+	s := d.sectors[n]
+	return d.file.Tracks[s.track].sectorData(s.index)
+}
+
+// Read reads from the current position.
+func (d *BlockDevice) Read(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if d.pos >= d.Size() {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, io.EOF
+		}
+		sector, off := int(d.pos/int64(d.size)), int(d.pos%int64(d.size))
+		var copied int
+		if data := d.sector(sector); data == nil {
+			copied = min(len(p)-n, d.size-off)
+			clear(p[n : n+copied])
+		} else {
+			copied = copy(p[n:], data[off:])
+		}
+		n += copied
+		d.pos += int64(copied)
+	}
+	return n, nil
+}
+
+// Write writes at the current position. Writing past the end of the device
+// fails with io.ErrShortWrite after writing what fits.
+func (d *BlockDevice) Write(p []byte) (int, error) {
+	var n int
+	for n < len(p) {
+		if d.pos >= d.Size() {
+			return n, io.ErrShortWrite
+		}
+		sector, off := int(d.pos/int64(d.size)), int(d.pos%int64(d.size))
+		data, ok := d.dirty[sector]
+		if !ok {
+			data = make([]byte, d.size)
+			copy(data, d.sector(sector))
+			d.dirty[sector] = data
+		}
+		copied := copy(data[off:], p[n:])
+		n += copied
+		d.pos += int64(copied)
+	}
+	return n, nil
+}
+
+// Seek sets the position for the next Read or Write.
+func (d *BlockDevice) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.pos
+	case io.SeekEnd:
+		offset += d.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	d.pos = offset
+	return offset, nil
+}
+
+// Flush stores the sectors written since the last Flush in the File as good
+// sectors, with their deleted status dropped and compressed whenever their
+// data is uniform.
+func (d *BlockDevice) Flush() error {
+	for n, data := range d.dirty {
+		s := d.sectors[n]
+		t := &d.file.Tracks[s.track]
+		t.fillRecords()
+		t.SectorRecordTypes[s.index] = minimalRecordType(RecordNormal, data)
+		t.SectorDataRecords[s.index] = data
+	}
+	clear(d.dirty)
+	return nil
+}
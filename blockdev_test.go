@@ -0,0 +1,66 @@
+package imd
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestBlockDevice(t *testing.T) {
+	g, _ := FormatGeometry("360K")
+	file := NewBlankImage(g, 0xF6, "", "")
+	track := file.Track(1, 0)
+	track.SectorRecordTypes[track.sectorIndex(2)] = RecordUnavailable
+
+	if _, err := file.AsBlockDevice(256); err == nil {
+		t.Error("wrong sector size accepted")
+	}
+	d, err := file.AsBlockDevice(512)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ io.ReadWriteSeeker = d
+	if d.Size() != 360*1024 {
+		t.Fatalf("Size() = %d", d.Size())
+	}
+
+	// From the end of sector 1 of track 1/0 through the unavailable sector 2
+	// and beyond.
+	const start = 19*512 - 100
+	payload := bytes.Repeat([]byte{1, 2, 3}, 400)
+	d.Seek(start, io.SeekStart)
+	if n, err := d.Write(payload); n != len(payload) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if raw, _ := file.RawImage(RawImageOptions{}); raw[start] != 0xF6 {
+		t.Error("write reached the file before Flush")
+	}
+
+	got := make([]byte, len(payload)+100)
+	d.Seek(start-100, io.SeekStart)
+	if _, err := io.ReadFull(d, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got[100:], payload) || got[0] != 0xF6 {
+		t.Error("read does not see buffered writes")
+	}
+
+	if err := d.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := file.RawImage(RawImageOptions{})
+	if !bytes.Equal(raw[start:start+len(payload)], payload) {
+		t.Error("flushed data not in the file")
+	}
+	if track.recordType(track.sectorIndex(2)) != RecordNormal {
+		t.Error("written unavailable sector not marked normal")
+	}
+
+	d.Seek(-10, io.SeekEnd)
+	if n, err := d.Write(make([]byte, 20)); n != 10 || err != io.ErrShortWrite {
+		t.Errorf("Write past the end = %d, %v", n, err)
+	}
+	if n, err := d.Read(got); n != 0 || err != io.EOF {
+		t.Errorf("Read at the end = %d, %v", n, err)
+	}
+}
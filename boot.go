@@ -0,0 +1,33 @@
+package imd
+
+import (
+	"errors"
+	"strings"
+)
+
+// bootSector returns the data of the lowest-numbered sector of track 0/0.
+func (f File) bootSector() ([]byte, error) {
+	t := f.Track(0, 0)
+	if t == nil || len(t.SectorNumberingMap) == 0 {
+		return nil, errors.New("no boot track")
+	}
+	data := t.sectorData(t.logicalOrder()[0])
+	if data == nil {
+		return nil, errors.New("boot sector is unavailable")
+	}
+	return data, nil
+}
+
+// OEMName returns the OEM name of a PC boot sector, the eight bytes from
+// offset 3 such as "MSDOS5.0" or "IBM  3.3", with trailing spaces and NULs
+// trimmed. It fails if the boot sector is unavailable or too short.
+func (f File) OEMName() (string, error) {
+	boot, err := f.bootSector()
+	if err != nil {
+		return "", err
+	}
+	if len(boot) < 11 {
+		return "", errors.New("boot sector too short")
+	}
+	return strings.TrimRight(string(boot[3:11]), " \x00"), nil
+}
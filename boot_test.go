@@ -0,0 +1,29 @@
+package imd
+
+import "testing"
+
+func TestOEMName(t *testing.T) {
+	g, _ := FormatGeometry("360K")
+	file := NewBlankImage(g, 0xF6, "", "")
+	track := &file.Tracks[0]
+	boot := track.SectorDataRecords[track.sectorIndex(1)]
+	copy(boot[3:], "IBM  3.3")
+	track.SectorRecordTypes[track.sectorIndex(1)] = RecordNormal
+
+	if name, err := file.OEMName(); err != nil || name != "IBM  3.3" {
+		t.Errorf("OEMName() = %q, %v", name, err)
+	}
+
+	copy(boot[3:], "MSWIN\x00\x00\x00")
+	if name, _ := file.OEMName(); name != "MSWIN" {
+		t.Errorf("OEMName() = %q, want MSWIN", name)
+	}
+
+	track.SectorRecordTypes[track.sectorIndex(1)] = RecordUnavailable
+	if _, err := file.OEMName(); err == nil {
+		t.Error("no error for an unavailable boot sector")
+	}
+	if _, err := (File{}).OEMName(); err == nil {
+		t.Error("no error without tracks")
+	}
+}
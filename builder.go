@@ -0,0 +1,128 @@
+package imd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ImageBuilder assembles a File track by track. Its methods can be chained;
+// mistakes are only reported by Build.
+type ImageBuilder struct {
+	file File
+	mode byte
+	err  error
+}
+
+// NewImageBuilder starts a File with the given header and comment. Tracks
+// added with AddRawTrack use mode 5, 250 kbps MFM, until WithMode says
+// otherwise.
+func NewImageBuilder(h Header, comment string) *ImageBuilder {
+	return &ImageBuilder{file: File{Header: h, Comment: comment}, mode: 5}
+}
+
+// WithMode sets the mode of the tracks added by later calls to AddRawTrack.
+func (b *ImageBuilder) WithMode(mode byte) *ImageBuilder {
+	b.mode = mode
+	return b
+}
+
+// AddTrack adds a copy of t.
+func (b *ImageBuilder) AddTrack(t Track) *ImageBuilder {
+	b.file.Tracks = append(b.file.Tracks, t.clone())
+	return b
+}
+
+// AddRawTrack adds a track holding copies of sectors as normal sectors,
+// numbered from 1 in the order given. A nil sector is added as unavailable.
+func (b *ImageBuilder) AddRawTrack(cyl, head, sizeCode byte, sectors [][]byte) *ImageBuilder {
+	if len(sectors) > 0xFF {
+		b.fail(fmt.Errorf("track %d/%d: %d sectors do not fit a track", cyl, head, len(sectors)))
+		return b
+	}
+
+	t := Track{
+		ModeValue:       b.mode,
+		Cylinder:        cyl,
+		Head:            head,
+		NumberOfSectors: byte(len(sectors)),
+		SectorSize:      sizeCode,
+	}
+	for i, data := range sectors {
+		record := byte(RecordNormal)
+		if data == nil {
+			record = RecordUnavailable
+		}
+		t.SectorNumberingMap = append(t.SectorNumberingMap, byte(i+1))
+		t.SectorRecordTypes = append(t.SectorRecordTypes, record)
+		t.SectorDataRecords = append(t.SectorDataRecords, slices.Clone(data))
+	}
+	b.file.Tracks = append(b.file.Tracks, t)
+	return b
+}
+
+func (b *ImageBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Build validates the image and returns it. It fails on the first mistake
+// made while adding tracks, an invalid header, a track that is inconsistent
+// (see Track.CheckConsistency), has an invalid mode, size code or record
+// type or repeats a sector number, or two tracks with the same address.
+func (b *ImageBuilder) Build() (File, error) {
+	if b.err != nil {
+		return File{}, b.err
+	}
+	if err := validateHeader(b.file.Header); err != nil {
+		return File{}, fmt.Errorf("header: %w", err)
+	}
+
+	seen := map[[2]byte]bool{}
+	for _, t := range b.file.Tracks {
+		key := [2]byte{t.Cylinder, t.headNumber()}
+		if err := checkTrack(t, seen[key]); err != nil {
+			return File{}, fmt.Errorf("track %d/%d: %w", t.Cylinder, t.headNumber(), err)
+		}
+		seen[key] = true
+	}
+
+	file := b.file
+	file.Tracks = slices.Clone(file.Tracks)
+	return file, nil
+}
+
+func checkTrack(t Track, duplicate bool) error {
+	if duplicate {
+		return errors.New("track added twice")
+	}
+	if _, ok := t.Mode(); !ok {
+		return fmt.Errorf("invalid mode %d", t.ModeValue)
+	}
+	if err := t.CheckConsistency(); err != nil {
+		return err
+	}
+	if t.Unformatted {
+		return nil
+	}
+	if t.SectorSize > maxSectorSizeCode {
+		return fmt.Errorf("invalid size code %d", t.SectorSize)
+	}
+	var numbers [256]bool
+	for _, n := range t.SectorNumberingMap {
+		if numbers[n] {
+			return fmt.Errorf("sector %d appears twice", n)
+		}
+		numbers[n] = true
+	}
+	for i, record := range t.SectorRecordTypes {
+		if record > RecordDeletedErrorCompressed {
+			return fmt.Errorf("sector %d has invalid record type %d", t.SectorNumberingMap[i], record)
+		}
+		if record != RecordUnavailable && t.sectorData(i) == nil {
+			return fmt.Errorf("sector %d has no data", t.SectorNumberingMap[i])
+		}
+	}
+	return nil
+}
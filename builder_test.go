@@ -0,0 +1,52 @@
+package imd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestImageBuilder(t *testing.T) {
+	header := Header("IMD 1.18: 17/10/2014 23:41:07")
+	sector := bytes.Repeat([]byte{1, 2}, 64)
+	sssd, _ := sssdImage(t)
+
+	file, err := NewImageBuilder(header, "\r\nbuilt\r\n").
+		AddTrack(sssd.Tracks[0]).
+		WithMode(0).
+		AddRawTrack(1, 0, 0, [][]byte{sector, nil, sector}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(file.Tracks) != 2 || file.Comment != "\r\nbuilt\r\n" {
+		t.Fatalf("file = %d tracks, comment %q", len(file.Tracks), file.Comment)
+	}
+	raw := file.Tracks[1]
+	if raw.ModeValue != 0 || !bytes.Equal(raw.SectorNumberingMap, []byte{1, 2, 3}) || raw.recordType(1) != RecordUnavailable {
+		t.Errorf("raw track = %+v", raw)
+	}
+	sector[0] = 0
+	if raw.SectorDataRecords[0][0] != 1 {
+		t.Error("AddRawTrack does not copy the sectors")
+	}
+	if err := Encode(&bytes.Buffer{}, file); err != nil {
+		t.Error(err)
+	}
+
+	bad := sssd.Tracks[1].clone()
+	bad.SectorNumberingMap[1] = bad.SectorNumberingMap[0]
+	for name, b := range map[string]*ImageBuilder{
+		"header":       NewImageBuilder("IMD", ""),
+		"duplicate":    NewImageBuilder(header, "").AddTrack(sssd.Tracks[0]).AddTrack(sssd.Tracks[0]),
+		"sector twice": NewImageBuilder(header, "").AddTrack(bad),
+		"short sector": NewImageBuilder(header, "").AddRawTrack(0, 0, 1, [][]byte{sector}),
+		"mode":         NewImageBuilder(header, "").WithMode(9).AddRawTrack(0, 0, 0, [][]byte{sector}),
+		"size code":    NewImageBuilder(header, "").AddRawTrack(0, 0, 7, nil),
+		"too many":     NewImageBuilder(header, "").AddRawTrack(0, 0, 0, make([][]byte, 256)),
+	} {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("%s: Build accepted a broken image", name)
+		}
+	}
+}
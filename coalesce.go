@@ -0,0 +1,97 @@
+package imd
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// SectorRef addresses a sector by cylinder, head and logical sector number.
+type SectorRef struct {
+	Cylinder,
+	Head,
+	Sector byte
+}
+
+// Coalesce merges several dumps of the same disk into one image holding the
+// best read of every sector: a good read, deleted or not, wins over one with a
+// data error, which wins over an unavailable sector. Among equally good reads
+// the one from the earliest image is kept. Tracks and sectors missing from
+// some images are taken from the others.
+//
+// The result has the header, comment and track order of the first image,
+// with tracks only found in later images appended. It also lists the sectors
+// that no image read without error, in track and physical order. Coalesce
+// fails if the images disagree on the sector size of a track.
+func Coalesce(images []File) (File, []SectorRef, error) {
+	if len(images) == 0 {
+		return File{}, nil, errors.New("no images")
+	}
+
+	merged := File{Header: images[0].Header, Comment: images[0].Comment}
+	for _, image := range images {
+		for _, t := range image.Tracks {
+			to := merged.Track(t.Cylinder, t.headNumber())
+			switch {
+			case t.Unformatted && to != nil:
+				continue
+			case to == nil:
+				merged.Tracks = append(merged.Tracks, t.clone())
+				continue
+			case to.Unformatted:
+				*to = t.clone()
+				continue
+			}
+			if err := coalesceTrack(to, t); err != nil {
+				return File{}, nil, fmt.Errorf("track %d/%d: %w", t.Cylinder, t.headNumber(), err)
+			}
+		}
+	}
+
+	var bad []SectorRef
+	for _, t := range merged.Tracks {
+		for i, number := range t.SectorNumberingMap {
+			if readQuality(t.recordType(i)) < readQuality(RecordNormal) {
+				bad = append(bad, SectorRef{t.Cylinder, t.headNumber(), number})
+			}
+		}
+	}
+	return merged, bad, nil
+}
+
+// coalesceTrack improves the sectors of dst with better reads from src.
+func coalesceTrack(dst *Track, src Track) error {
+	if dst.SectorSize != src.SectorSize {
+		return errors.New("sector sizes differ")
+	}
+	dst.fillRecords()
+	for i, number := range src.SectorNumberingMap {
+		record := src.recordType(i)
+		data := slices.Clone(src.sectorData(i))
+
+		j := dst.sectorIndex(number)
+		if j < 0 {
+			if dst.NumberOfSectors == 0xFF {
+				return fmt.Errorf("too many sectors to add sector %d", number)
+			}
+			dst.appendSector(number, record, data)
+			continue
+		}
+		if readQuality(record) > readQuality(dst.recordType(j)) {
+			dst.SectorRecordTypes[j] = record
+			dst.SectorDataRecords[j] = data
+		}
+	}
+	return nil
+}
+
+// readQuality ranks record types by how trustworthy their data is.
+func readQuality(record byte) int {
+	switch {
+	case record == RecordUnavailable:
+		return 0
+	case recordStatus(record) >= RecordError:
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,48 @@
+package imd
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestCoalesce(t *testing.T) {
+	first, data := sssdImage(t)
+	first.Tracks = first.Tracks[:3]
+	second := File{Tracks: []Track{first.Tracks[1].clone(), first.Tracks[0].clone()}}
+
+	track := &first.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(2)] = RecordUnavailable
+	track.SectorRecordTypes[track.sectorIndex(3)] = RecordError
+	track.SectorDataRecords[track.sectorIndex(3)] = bytes.Repeat([]byte{0xFF}, 128)
+	track.SectorRecordTypes[track.sectorIndex(4)] = RecordError
+
+	track = &second.Tracks[0]
+	track.SectorRecordTypes[track.sectorIndex(4)] = RecordUnavailable
+	track.SectorRecordTypes[track.sectorIndex(5)] = RecordDeletedError
+	track = &second.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(1)] = RecordUnavailable
+
+	merged, bad, err := Coalesce([]File{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []SectorRef{{1, 0, 4}}; !slices.Equal(bad, want) {
+		t.Errorf("bad sectors = %v, want %v", bad, want)
+	}
+	if len(merged.Tracks) != 3 || merged.Tracks[2].Cylinder != 2 {
+		t.Fatalf("merged image has %d tracks", len(merged.Tracks))
+	}
+	raw, _ := merged.RawImage(RawImageOptions{})
+	if !bytes.Equal(raw, data[:3*26*128]) {
+		t.Error("merged image does not hold the good reads")
+	}
+	if first.Tracks[1].recordType(first.Tracks[1].sectorIndex(2)) != RecordUnavailable {
+		t.Error("Coalesce modified its input")
+	}
+
+	second.Tracks[0].SectorSize = 1
+	if _, _, err := Coalesce([]File{first, second}); err == nil {
+		t.Error("differing sector sizes accepted")
+	}
+}
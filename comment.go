@@ -0,0 +1,75 @@
+package imd
+
+import "strings"
+
+// NormalizeComment prepares s for use as a File comment the way the reference
+// ImageDisk tool writes them: lone LFs become CRLF and any 0x1A, which would
+// terminate the comment early, is removed.
+func NormalizeComment(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	var prev byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 0x1A:
+			continue
+		case '\n':
+			if prev != '\r' {
+				b.WriteByte('\r')
+			}
+		}
+		b.WriteByte(s[i])
+		prev = s[i]
+	}
+
+	return b.String()
+}
+
+// CommentBytes returns a copy of f's comment exactly as stored in the image.
+// Comments written on DOS often hold code page 437 box-drawing characters,
+// which are not valid UTF-8; Comment keeps them byte for byte too, but ranging
+// over it or converting it to runes replaces them, so byte-oriented callers
+// should use this instead.
+func (f File) CommentBytes() []byte {
+	return []byte(f.Comment)
+}
+
+// Metadata parses the lines of f's comment that have the form "Key: Value"
+// into a map, trimming spaces around both parts. Other lines are ignored and
+// a later line wins over an earlier one with the same key.
+func (f File) Metadata() map[string]string {
+	m := map[string]string{}
+	for _, line := range splitLines(f.Comment) {
+		key, value, ok := strings.Cut(line, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		m[key] = strings.TrimSpace(value)
+	}
+	return m
+}
+
+// CommentLines returns the lines of f's comment, split on CRLF or LF. The
+// line break that ends the header line and the one ending the last line do
+// not produce empty lines of their own.
+func (f File) CommentLines() []string {
+	lines := splitLines(f.Comment)
+	if lines[0] == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splitLines splits s on CRLF and LF line endings.
+func splitLines(s string) []string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
@@ -0,0 +1,54 @@
+package imd
+
+import (
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestNormalizeComment(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"a\nb\n":             "a\r\nb\r\n",
+		"a\r\nb":             "a\r\nb",
+		"\na\x1a\r\n\x1a\n":  "\r\na\r\n\r\n",
+		"a\r\x1a\nb":         "a\r\nb",
+		"\xc4\xcd\xcd\xb3\n": "\xc4\xcd\xcd\xb3\r\n",
+	}
+
+	for in, want := range tests {
+		if got := NormalizeComment(in); got != want {
+			t.Errorf("NormalizeComment(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	file := File{Comment: "\r\nDumped-By: someone\r\nOriginal-Label:  WordStar 3.0 \nfree text\n: no key\nDumped-By: someone else"}
+
+	want := map[string]string{
+		"Dumped-By":      "someone else",
+		"Original-Label": "WordStar 3.0",
+	}
+	if got := file.Metadata(); !maps.Equal(got, want) {
+		t.Errorf("Metadata() = %q, want %q", got, want)
+	}
+}
+
+func TestCommentLines(t *testing.T) {
+	tests := []struct {
+		comment string
+		want    []string
+	}{
+		{"\r\nfirst\r\nsecond\r\n", []string{"first", "second"}},
+		{"first\nsecond", []string{"first", "second"}},
+		{"\r\n\r\nafter a blank line\n\nbefore one\r\n", []string{"", "after a blank line", "", "before one"}},
+		{"\r\n", []string{}},
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		if got := (File{Comment: test.comment}).CommentLines(); !slices.Equal(got, test.want) {
+			t.Errorf("CommentLines(%q) = %q, want %q", test.comment, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package imd
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1F, 0x8B}
+	zstdMagic = []byte{0x28, 0xB5, 0x2F, 0xFD}
+)
+
+// DecodeMaybeCompressed decodes an image that may be gzip or zstd compressed,
+// telling them apart from a plain image by their magic numbers. The image is
+// decoded as it is decompressed, so memory use is bounded by the decoded
+// File rather than by the size of the decompressed stream. The image must
+// make up the whole of the (decompressed) input: another image after it fails
+// the decode with ErrTrailingData, and other trailing bytes fail it as a
+// malformed track.
+func DecodeMaybeCompressed(r io.Reader) (File, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+
+	src := br
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return File{}, err
+		}
+		defer zr.Close()
+		src = bufio.NewReader(zr)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return File{}, err
+		}
+		defer zr.Close()
+		src = bufio.NewReader(zr)
+	}
+
+	return DecodeWithOptions(src, DecodeOptions{StrictEOF: true})
+}
@@ -0,0 +1,50 @@
+package imd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestDecodeMaybeCompressed(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+
+	zw, _ := zstd.NewWriter(nil)
+	zst := zw.EncodeAll(data, nil)
+	zw.Close()
+
+	for name, input := range map[string][]byte{"plain": data, "gzip": gz.Bytes(), "zstd": zst} {
+		file, err := DecodeMaybeCompressed(bytes.NewReader(input))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if file.CRC32() != want.CRC32() {
+			t.Errorf("%s: decoded image differs", name)
+		}
+	}
+
+	gz.Reset()
+	gw = gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Write(data)
+	gw.Close()
+	if _, err := DecodeMaybeCompressed(&gz); err != ErrTrailingData {
+		t.Errorf("second image: err = %v, want ErrTrailingData", err)
+	}
+}
@@ -0,0 +1,86 @@
+package imd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// DecodeContext is like DecodeWithOptions but gives up once ctx is done,
+// returning ctx.Err() even if the reader is stalled.
+//
+// If r has a SetReadDeadline method, as net.Conn does, the deadline of ctx is
+// applied to it and cancellation sets a deadline in the past, so blocked
+// reads return promptly; the deadline is left in place afterwards. Other
+// readers are read through a buffer, from a separate goroutine for every
+// refill, so the decode may consume input past the end of the image. A read
+// abandoned on cancellation keeps that goroutine running until r returns, and
+// r must not be used for anything else after a cancelled decode.
+func DecodeContext(ctx context.Context, r io.Reader, opts DecodeOptions) (File, error) {
+	if err := ctx.Err(); err != nil {
+		return File{}, err
+	}
+	if ctx.Done() == nil {
+		return DecodeWithOptions(r, opts)
+	}
+
+	var deadline bool
+	if d, ok := r.(interface{ SetReadDeadline(time.Time) error }); ok {
+		if t, ok := ctx.Deadline(); ok {
+			d.SetReadDeadline(t)
+			deadline = true
+		}
+		stop := context.AfterFunc(ctx, func() {
+			d.SetReadDeadline(time.Unix(1, 0))
+		})
+		defer stop()
+	} else {
+		r = bufio.NewReader(&contextReader{ctx: ctx, r: r})
+	}
+
+	file, err := DecodeWithOptions(r, opts)
+	if deadline && errors.Is(err, os.ErrDeadlineExceeded) {
+		// The reader's clock can run out just before the context's.
+		<-ctx.Done()
+	}
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return file, err
+}
+
+// contextReader reads from r in a goroutine so that a read can be abandoned
+// when ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// An abandoned read must not write into p once Read has returned.
+	buf := make([]byte, len(p))
+	done := make(chan readResult, 1)
+	go func() {
+		n, err := c.r.Read(buf)
+		done <- readResult{n, err}
+	}()
+
+	select {
+	case res := <-done:
+		return copy(p, buf[:res.n]), res.err
+	case <-c.ctx.Done():
+		return 0, c.ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package imd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDecodeContext(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 0, 0, 1, 0, 1, RecordCompressed, 0xE5)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	file, err := DecodeContext(ctx, bytes.NewReader(data), DecodeOptions{StrictEOF: true})
+	if err != nil || len(file.Tracks) != 1 {
+		t.Fatalf("DecodeContext = %d tracks, %v", len(file.Tracks), err)
+	}
+
+	// A stream that stalls after the header.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(data[:0x1D])
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := DecodeContext(ctx, pr, DecodeOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("stalled pipe: err = %v, want context.Canceled", err)
+	}
+}
+
+// readCounter counts the Read calls made on r.
+type readCounter struct {
+	r     io.Reader
+	calls int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	c.calls++
+	return c.r.Read(p)
+}
+
+func TestDecodeContextBuffersReads(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := &readCounter{r: bytes.NewReader(data)}
+	file, err := DecodeContext(ctx, r, DecodeOptions{})
+	if err != nil || len(file.Tracks) != 80 {
+		t.Fatalf("DecodeContext = %d tracks, %v", len(file.Tracks), err)
+	}
+	if limit := len(data)/4096 + 4; r.calls > limit {
+		t.Errorf("%d reads of the source, want at most %d", r.calls, limit)
+	}
+}
+
+func TestDecodeContextDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go server.Write([]byte("IMD 1.18: 17/10/2014 23:41:07"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := DecodeContext(ctx, client, DecodeOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stalled conn: err = %v, want context.DeadlineExceeded", err)
+	}
+}
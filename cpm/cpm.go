@@ -0,0 +1,163 @@
+// Package cpm reads the directory of CP/M 2.2 filesystems stored in IMD
+// images.
+package cpm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"imd"
+)
+
+// Params describes the layout of a CP/M filesystem, as given by the disk
+// parameter block of the system that wrote it. CP/M disks do not record it.
+type Params struct {
+	// ReservedTracks is the number of system tracks before the directory,
+	// counted in the order of the image's tracks.
+	ReservedTracks int
+	// BlockSize is the allocation block size in bytes, 1024 to 16384. With
+	// the size of the data area it decides the width of block pointers.
+	BlockSize int
+	// DirEntries is the number of 32-byte directory entries.
+	DirEntries int
+}
+
+// DirEntry is a file listed in a CP/M directory.
+type DirEntry struct {
+	User byte
+	// Name is the file name and extension joined by a dot, such as
+	// "PIP.COM", without attribute bits.
+	Name string
+	// Size is the file size in bytes, rounded up to 128-byte records.
+	Size int64
+	// Blocks lists the allocation blocks of the file in directory order.
+	Blocks []int
+}
+
+const entryUnused = 0xE5
+
+// ReadDirectory lists the files of the CP/M filesystem in file, in directory
+// order. The tracks of file must be in cylinder and head order.
+//
+// Some systems erase directory sectors by rewriting them with a deleted data
+// address mark instead of marking each entry unused. With honorDeletedMarks
+// set, directory sectors recorded as deleted are treated as empty, which is
+// what those systems see; otherwise their stale entries are listed as well.
+func ReadDirectory(file imd.File, p Params, honorDeletedMarks bool) ([]DirEntry, error) {
+	if p.BlockSize < 1024 || p.BlockSize&(p.BlockSize-1) != 0 || p.DirEntries <= 0 {
+		return nil, errors.New("invalid parameters")
+	}
+	if p.ReservedTracks < 0 || p.ReservedTracks >= len(file.Tracks) {
+		return nil, errors.New("no tracks after the reserved ones")
+	}
+
+	dir, err := readDirectory(file.Tracks[p.ReservedTracks:], p.DirEntries*32, honorDeletedMarks)
+	if err != nil {
+		return nil, err
+	}
+
+	// Block pointers are 16 bits wide once there are more than 256 blocks.
+	var size int
+	for _, t := range file.Tracks[p.ReservedTracks:] {
+		size += len(t.SectorNumberingMap) * imd.SectorSizeBytes(t.SectorSize)
+	}
+	wide := size/p.BlockSize > 256
+
+	var entries []DirEntry
+	type fileKey struct {
+		user byte
+		name string
+	}
+	index := map[fileKey]int{}
+	for off := 0; off < len(dir); off += 32 {
+		e := dir[off : off+32]
+		if e[0] == entryUnused || e[0] > 15 {
+			continue
+		}
+
+		name := strings.TrimRight(clearHighBits(e[1:9]), " ")
+		if ext := strings.TrimRight(clearHighBits(e[9:12]), " "); ext != "" {
+			name += "." + ext
+		}
+		extent, records := int64(e[12]&0x1F)+32*int64(e[14]&0x3F), int64(e[15])
+		fileSize := (extent*128 + records) * 128
+
+		key := fileKey{e[0], name}
+		i, ok := index[key]
+		if !ok {
+			i = len(entries)
+			index[key] = i
+			entries = append(entries, DirEntry{User: e[0], Name: name})
+		}
+		entries[i].Size = max(entries[i].Size, fileSize)
+		entries[i].Blocks = append(entries[i].Blocks, blocks(e[16:], wide)...)
+	}
+	return entries, nil
+}
+
+// readDirectory returns the first n bytes of the logical sectors of tracks,
+// with the sectors skipped by honorDeletedMarks reading as unused entries.
+func readDirectory(tracks []imd.Track, n int, honorDeletedMarks bool) ([]byte, error) {
+	var dir []byte
+	for _, t := range tracks {
+		for _, number := range t.LogicalSectors() {
+			if len(dir) >= n {
+				return dir[:n], nil
+			}
+
+			i := slices.Index(t.SectorNumberingMap, number)
+			var record byte = imd.RecordNormal
+			if i < len(t.SectorRecordTypes) {
+				record = t.SectorRecordTypes[i]
+			}
+			if record == imd.RecordUnavailable || i >= len(t.SectorDataRecords) {
+				return nil, fmt.Errorf("directory sector %d of track %d/%d is unavailable", number, t.Cylinder, t.Head&0x3F)
+			}
+
+			data := t.SectorDataRecords[i]
+			if honorDeletedMarks && isDeleted(record) {
+				data = bytes.Repeat([]byte{entryUnused}, len(data))
+			}
+			dir = append(dir, data...)
+		}
+	}
+	if len(dir) < n {
+		return nil, errors.New("directory runs past the end of the image")
+	}
+	return dir[:n], nil
+}
+
+// blocks returns the non-zero block pointers of an allocation map.
+func blocks(m []byte, wide bool) []int {
+	var blocks []int
+	for i := 0; i < len(m); i++ {
+		block := int(m[i])
+		if wide {
+			block |= int(m[i+1]) << 8
+			i++
+		}
+		if block != 0 {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+func isDeleted(record byte) bool {
+	switch record {
+	case imd.RecordDeleted, imd.RecordDeletedCompressed, imd.RecordDeletedError, imd.RecordDeletedErrorCompressed:
+		return true
+	}
+	return false
+}
+
+func clearHighBits(b []byte) string {
+	s := make([]byte, len(b))
+	for i, c := range b {
+		s[i] = c & 0x7F
+	}
+	return string(s)
+}
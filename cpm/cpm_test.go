@@ -0,0 +1,126 @@
+package cpm
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+
+	"imd"
+)
+
+// qx10 is the layout of Epson QX-10 CP/M disks like disk01.imd.
+var qx10 = Params{ReservedTracks: 4, BlockSize: 2048, DirEntries: 64}
+
+func openDisk(t *testing.T) imd.File {
+	t.Helper()
+	data, err := os.ReadFile("../disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf, err := imd.OpenLazy(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := imd.File{Header: lf.Header, Comment: lf.Comment}
+	for _, lt := range lf.Tracks {
+		track, err := lt.Track()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Tracks = append(file.Tracks, track)
+	}
+	return file
+}
+
+func find(entries []DirEntry, name string) *DirEntry {
+	for i := range entries {
+		if entries[i].Name == name {
+			return &entries[i]
+		}
+	}
+	return nil
+}
+
+func TestReadDirectory(t *testing.T) {
+	file := openDisk(t)
+
+	entries, err := ReadDirectory(file, qx10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, size := range map[string]int64{
+		"CATALOG.CP+": 10 * 128,
+		"CP+.003":     (128 + 4) * 128,
+		"PIP.COM":     0x3A * 128,
+		"RFILE":       5 * 128,
+	} {
+		if e := find(entries, name); e == nil || e.Size != size || e.User != 0 {
+			t.Errorf("%s: entry %+v, want size %d", name, e, size)
+		}
+	}
+
+	if e := find(entries, "CP+.003"); e == nil || !slices.Equal(e.Blocks, []int{15, 16, 17, 18, 19, 20, 21, 22, 23}) {
+		t.Errorf("CP+.003 blocks = %+v", e)
+	}
+
+	// Erase the second directory sector with a deleted data mark.
+	track := &file.Tracks[4]
+	i := bytes.IndexByte(track.SectorNumberingMap, 2)
+	track.SectorRecordTypes[i] = imd.RecordDeleted
+
+	stale, err := ReadDirectory(file, qx10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	honored, err := ReadDirectory(file, qx10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stale) != len(entries) || find(stale, "PIP.COM") == nil {
+		t.Error("deleted mark changed the listing without honorDeletedMarks")
+	}
+	if find(honored, "PIP.COM") != nil || find(honored, "CATALOG.CP+") == nil {
+		t.Error("honorDeletedMarks did not drop the erased sector's entries")
+	}
+}
+
+func TestReadDirectoryInvalidParams(t *testing.T) {
+	file := openDisk(t)
+	for _, p := range []Params{
+		{ReservedTracks: 4, BlockSize: 1000, DirEntries: 64},
+		{ReservedTracks: 4, BlockSize: 2048},
+		{ReservedTracks: 80, BlockSize: 2048, DirEntries: 64},
+		{ReservedTracks: 79, BlockSize: 2048, DirEntries: 4096},
+	} {
+		if _, err := ReadDirectory(file, p, false); err == nil {
+			t.Errorf("%+v: no error", p)
+		}
+	}
+}
+
+func TestReadDirectoryUsers(t *testing.T) {
+	raw := bytes.Repeat([]byte{entryUnused}, 2*8*128)
+	for i, e := range []struct {
+		user byte
+		name string
+	}{{1, "1A      COM"}, {11, "A       COM"}} {
+		entry := raw[i*32 : (i+1)*32]
+		clear(entry)
+		entry[0] = e.user
+		copy(entry[1:], e.name)
+		entry[15], entry[16] = 1, byte(i+1)
+	}
+	file, err := imd.FromRawImage(raw, imd.Geometry{Cylinders: 2, Heads: 1, SectorsPerTrack: 8, SectorSize: 128, SectorBase: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := ReadDirectory(file, Params{BlockSize: 1024, DirEntries: 8}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].User != 1 || entries[0].Name != "1A.COM" || entries[1].User != 11 || entries[1].Name != "A.COM" {
+		t.Errorf("entries = %+v", entries)
+	}
+}
@@ -0,0 +1,14 @@
+package imd
+
+import "hash/crc32"
+
+// CRC32 returns the IEEE CRC-32 of f as written by Encode. For a decoded
+// image this matches the CRC of the original file whenever Encode reproduces
+// it byte for byte. CRC32 returns 0 if f cannot be encoded.
+func (f File) CRC32() uint32 {
+	h := crc32.NewIEEE()
+	if err := Encode(h, f); err != nil {
+		return 0
+	}
+	return h.Sum32()
+}
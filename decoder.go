@@ -0,0 +1,278 @@
+package imd
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Decoder reads an image track by track, so that large images can be
+// processed without holding all of their sector data in memory.
+type Decoder struct {
+	r       io.Reader
+	opts    DecodeOptions
+	counter *countingReader
+	arena   *arena
+	offsets []TrackOffsets
+
+	header  Header
+	comment string
+
+	// stop is set once Recover gave up on the rest of the image, err once
+	// NextTrack has returned its final error.
+	stop bool
+	err  error
+}
+
+// NewDecoder reads the header and comment of the image in r and returns a
+// Decoder for its tracks.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	return NewDecoderWithOptions(r, DecodeOptions{})
+}
+
+// NewDecoderWithOptions is like NewDecoder with the given options. Pool and
+// RecordOffsets are ignored, as they only apply to a decoded File. On
+// ErrTruncatedComment the returned Decoder holds the header and the comment
+// read so far.
+func NewDecoderWithOptions(r io.Reader, opts DecodeOptions) (*Decoder, error) {
+	opts.Pool, opts.RecordOffsets = false, false
+	d, err := newDecoder(r, opts)
+	if err != nil && err != ErrTruncatedComment {
+		return nil, err
+	}
+	return d, err
+}
+
+// newDecoder reads the header and comment of the image in r. The returned
+// Decoder holds whatever was read, even on error.
+func newDecoder(r io.Reader, opts DecodeOptions) (*Decoder, error) {
+	d := &Decoder{r: r, opts: opts}
+	if opts.RecordOffsets || opts.Metrics != nil {
+		d.counter = &countingReader{r: r}
+		d.r = d.counter
+	}
+	if opts.Metrics != nil {
+		opts.Metrics.Tracks = opts.Metrics.Tracks[:0]
+	}
+	if opts.Pool {
+		d.arena = &arena{}
+	}
+
+	var header [0x1D]byte
+	if _, err := io.ReadFull(d.r, header[:]); err != nil {
+		return d, err
+	}
+	d.header = Header(string(header[:]))
+	if err := validateHeader(d.header); err != nil {
+		return d, err
+	}
+
+	var err error
+	d.comment, err = readStringASCIIEOF(d.r)
+	if err == io.EOF {
+		err = ErrTruncatedComment
+	}
+	return d, err
+}
+
+// Header returns the header of the image.
+func (d *Decoder) Header() Header {
+	return d.header
+}
+
+// Comment returns the comment of the image.
+func (d *Decoder) Comment() string {
+	return d.comment
+}
+
+// NextTrack decodes the next track of the image. It returns io.EOF once the
+// input ends between two tracks or reaches the magic of another image, and
+// after Recover stopped at an unknown record type; with StrictEOF it returns
+// ErrTrailingData instead if any input remains. Once NextTrack has failed, it
+// keeps returning the same error.
+func (d *Decoder) NextTrack() (Track, error) {
+	if d.err != nil {
+		return Track{}, d.err
+	}
+	if d.stop {
+		d.err = d.end()
+		return Track{}, d.err
+	}
+
+	t, misaligned, err := d.readTrack()
+	switch {
+	case err == io.EOF:
+		d.err = d.end()
+		return Track{}, d.err
+	case err != nil:
+		d.err = err
+		return Track{}, err
+	}
+	d.stop = misaligned
+	return t, nil
+}
+
+// end returns the error marking the end of the tracks.
+func (d *Decoder) end() error {
+	if d.opts.StrictEOF {
+		if _, err := readByte(d.r); err == nil {
+			return ErrTrailingData
+		}
+	}
+	return io.EOF
+}
+
+// readTrack decodes the next track and reports whether Recover gave up on
+// the data after it. It returns io.EOF if there is no next track.
+func (d *Decoder) readTrack() (Track, bool, error) {
+	if nextIsHeader(d.r) {
+		return Track{}, false, io.EOF
+	}
+	var offsets TrackOffsets
+	var metrics TrackMetrics
+	var start time.Time
+	if d.counter != nil {
+		offsets.Track = d.counter.n
+	}
+	if d.opts.Metrics != nil {
+		start = time.Now()
+	}
+	modeValue, err := readByte(d.r)
+	if err != nil {
+		return Track{}, false, err
+	}
+	cylinder, err := readByte(d.r)
+	if err != nil {
+		return Track{}, false, noEOF(err)
+	}
+	head, err := readByte(d.r)
+	if err != nil {
+		return Track{}, false, noEOF(err)
+	}
+	numberOfSectors, err := readByte(d.r)
+	if err != nil {
+		return Track{}, false, noEOF(err)
+	}
+	sectorSize, err := readByte(d.r)
+	if err != nil {
+		return Track{}, false, noEOF(err)
+	}
+
+	if numberOfSectors == 0 {
+		d.opts.warn("track %d/%d has no sectors", cylinder, head&headNumberMask)
+	}
+
+	// An unformatted track is followed by nothing, whatever its sector
+	// count says.
+	unformatted := numberOfSectors == 0 || sectorSize == unformattedSizeCode
+	n := numberOfSectors
+	if unformatted {
+		n = 0
+	}
+	if _, ok := d.opts.SectorSizeOverride[sectorSize]; !unformatted && !ok && sectorSize > maxSectorSizeCode {
+		return Track{}, false, fmt.Errorf("track %d/%d: invalid size code %d", cylinder, head&headNumberMask, sectorSize)
+	}
+
+	var sectorNumberingMap, sectorCylinderMap, sectorHeadMap []byte
+
+	if !unformatted {
+		sectorNumberingMap = make([]byte, n)
+		if _, err := io.ReadFull(d.r, sectorNumberingMap); err != nil {
+			return Track{}, false, noEOF(err)
+		}
+
+		if head&sectorCylinderMapMask != 0 {
+			sectorCylinderMap = make([]byte, n)
+			if _, err := io.ReadFull(d.r, sectorCylinderMap); err != nil {
+				return Track{}, false, noEOF(err)
+			}
+		}
+
+		if head&sectorHeadMapMask != 0 {
+			sectorHeadMap = make([]byte, n)
+			if _, err := io.ReadFull(d.r, sectorHeadMap); err != nil {
+				return Track{}, false, noEOF(err)
+			}
+		}
+	}
+
+	var sectorRecordTypes = make([]byte, n)
+	var sectorDataRecords = make([][]byte, n)
+
+	if d.opts.RecordOffsets {
+		offsets.Sectors = make([]int64, n)
+	}
+
+	var misaligned bool
+	for i := byte(0); i < n; i++ {
+		if d.opts.RecordOffsets {
+			offsets.Sectors[i] = d.counter.n
+		}
+		if err := readBytePtr(d.r, &sectorRecordTypes[i]); err != nil {
+			return Track{}, false, noEOF(err)
+		}
+
+		switch sectorRecordTypes[i] {
+		case RecordUnavailable:
+			continue
+		case RecordNormal, RecordDeleted, RecordError, RecordDeletedError:
+			sectorDataRecords[i] = d.arena.alloc(d.opts.sectorSizeBytes(sectorSize))
+			if _, err := io.ReadFull(d.r, sectorDataRecords[i]); err != nil {
+				return Track{}, false, noEOF(err)
+			}
+			metrics.UncompressedSectors++
+		case RecordCompressed, RecordDeletedCompressed, RecordErrorCompressed, RecordDeletedErrorCompressed: // all bytes are the same
+			v, err := readByte(d.r)
+			if err != nil {
+				return Track{}, false, noEOF(err)
+			}
+			sectorDataRecords[i] = d.arena.alloc(d.opts.sectorSizeBytes(sectorSize))
+			fill(sectorDataRecords[i], v)
+			metrics.CompressedSectors++
+		default:
+			if d.opts.UnknownRecordHandler != nil {
+				data, err := d.opts.UnknownRecordHandler(sectorRecordTypes[i], d.r)
+				if err != nil {
+					return Track{}, false, fmt.Errorf("track %d/%d: sector %d: %w",
+						cylinder, head&headNumberMask, sectorNumberingMap[i], err)
+				}
+				sectorDataRecords[i] = data
+				continue
+			}
+			if !d.opts.Recover {
+				return Track{}, false, fmt.Errorf("track %d/%d: sector %d has unknown record type %d",
+					cylinder, head&headNumberMask, sectorNumberingMap[i], sectorRecordTypes[i])
+			}
+			d.opts.warn("track %d/%d: sector %d has unknown record type %d, stopping at misaligned data",
+				cylinder, head&headNumberMask, sectorNumberingMap[i], sectorRecordTypes[i])
+			sectorRecordTypes[i] = RecordUnavailable
+			misaligned = true
+		}
+		if misaligned {
+			break
+		}
+	}
+
+	if d.opts.RecordOffsets {
+		d.offsets = append(d.offsets, offsets)
+	}
+	if d.opts.Metrics != nil {
+		metrics.Cylinder, metrics.Head = cylinder, head&headNumberMask
+		metrics.Bytes = d.counter.n - offsets.Track
+		metrics.Duration = time.Since(start)
+		d.opts.Metrics.Tracks = append(d.opts.Metrics.Tracks, metrics)
+	}
+	return Track{
+		ModeValue:          modeValue,
+		Cylinder:           cylinder,
+		Head:               head,
+		NumberOfSectors:    numberOfSectors,
+		SectorSize:         sectorSize,
+		SectorNumberingMap: sectorNumberingMap,
+		SectorCylinderMap:  sectorCylinderMap,
+		SectorHeadMap:      sectorHeadMap,
+		SectorRecordTypes:  sectorRecordTypes,
+		SectorDataRecords:  sectorDataRecords,
+		Unformatted:        unformatted,
+	}, misaligned, nil
+}
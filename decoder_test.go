@@ -0,0 +1,81 @@
+package imd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDecoder(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Header() != want.Header || d.Comment() != want.Comment {
+		t.Errorf("header %q, comment %q", d.Header(), d.Comment())
+	}
+	var n int
+	for {
+		track, err := d.NextTrack()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !track.EqualContent(want.Tracks[n]) || track.Cylinder != want.Tracks[n].Cylinder {
+			t.Errorf("track %d differs from Decode", n)
+		}
+		n++
+	}
+	if n != len(want.Tracks) {
+		t.Errorf("got %d tracks, want %d", n, len(want.Tracks))
+	}
+	if _, err := d.NextTrack(); err != io.EOF {
+		t.Errorf("NextTrack after the end: %v", err)
+	}
+
+	if _, err := NewDecoder(bytes.NewReader(data[:20])); err == nil {
+		t.Error("truncated header accepted")
+	}
+}
+
+func TestDecoderStopsAtNextImage(t *testing.T) {
+	first := commentImage("\r\nfirst\r\n")
+	d, err := NewDecoderWithOptions(bufio.NewReader(bytes.NewReader(append(first, first...))), DecodeOptions{StrictEOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.NextTrack(); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := d.NextTrack(); err != ErrTrailingData {
+			t.Errorf("NextTrack = %v, want ErrTrailingData", err)
+		}
+	}
+}
+
+func TestDecoderTruncatedTrack(t *testing.T) {
+	data := commentImage("")
+	d, err := NewDecoder(bytes.NewReader(data[:len(data)-1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := d.NextTrack(); err != io.ErrUnexpectedEOF {
+			t.Errorf("NextTrack = %v, want io.ErrUnexpectedEOF", err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package imd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// WriteDiffReport writes a human-readable comparison of a and b to w: the
+// geometry fields that differ, every track present in only one image, every
+// sector whose presence, status or data differs, and a summary line. Sectors
+// are matched by logical number, so images with different interleave but the
+// same content compare equal. Record types are compared by status only, since
+// compression is a property of the encoding rather than of the disk.
+func WriteDiffReport(w io.Writer, a, b File) error {
+	bw := bufio.NewWriter(w)
+
+	ga, gb := a.Geometry(), b.Geometry()
+	for _, field := range []struct {
+		name string
+		a, b int
+	}{
+		{"cylinders", ga.Cylinders, gb.Cylinders},
+		{"heads", ga.Heads, gb.Heads},
+		{"sectors per track", ga.SectorsPerTrack, gb.SectorsPerTrack},
+		{"sector size", ga.SectorSize, gb.SectorSize},
+		{"mode", int(ga.ModeValue), int(gb.ModeValue)},
+	} {
+		if field.a != field.b {
+			fmt.Fprintf(bw, "geometry: %s %d vs %d\n", field.name, field.a, field.b)
+		}
+	}
+
+	ta, tb := a.TrackMap(), b.TrackMap()
+	var keys [][2]byte
+	for key := range ta {
+		keys = append(keys, key)
+	}
+	for key := range tb {
+		if _, ok := ta[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(x, y [2]byte) int {
+		return bytes.Compare(x[:], y[:])
+	})
+
+	var tracks, sectors int
+	for _, key := range keys {
+		x, y := ta[key], tb[key]
+		switch {
+		case y == nil:
+			fmt.Fprintf(bw, "track %d/%d: only in first image\n", key[0], key[1])
+			tracks++
+			continue
+		case x == nil:
+			fmt.Fprintf(bw, "track %d/%d: only in second image\n", key[0], key[1])
+			tracks++
+			continue
+		}
+
+		n := diffTrack(bw, *x, *y)
+		if n > 0 {
+			tracks++
+			sectors += n
+		}
+	}
+
+	if tracks == 0 {
+		fmt.Fprintf(bw, "images match: %d tracks compared\n", len(keys))
+	} else {
+		fmt.Fprintf(bw, "%d of %d tracks differ, %d sectors differ\n", tracks, len(keys), sectors)
+	}
+
+	return bw.Flush()
+}
+
+// diffTrack writes a line for every sector that differs between a and b and
+// returns how many did.
+func diffTrack(w io.Writer, a, b Track) int {
+	var numbers []byte
+	numbers = append(numbers, a.SectorNumberingMap...)
+	numbers = append(numbers, b.SectorNumberingMap...)
+	slices.Sort(numbers)
+	numbers = slices.Compact(numbers)
+
+	var n int
+	for _, number := range numbers {
+		i, j := a.sectorIndex(number), b.sectorIndex(number)
+		var ra, rb byte
+		if i >= 0 {
+			ra = recordStatus(a.recordType(i))
+		}
+		if j >= 0 {
+			rb = recordStatus(b.recordType(j))
+		}
+
+		var what string
+		switch {
+		case ra == RecordUnavailable && rb == RecordUnavailable:
+			continue
+		case rb == RecordUnavailable:
+			what = "only in first image"
+		case ra == RecordUnavailable:
+			what = "only in second image"
+		case ra != rb:
+			what = fmt.Sprintf("record type %d vs %d", ra, rb)
+		case !bytes.Equal(a.sectorData(i), b.sectorData(j)):
+			what = "data differs"
+		default:
+			continue
+		}
+		fmt.Fprintf(w, "track %d/%d: sector %d %s\n", a.Cylinder, a.headNumber(), number, what)
+		n++
+	}
+	return n
+}
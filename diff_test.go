@@ -0,0 +1,37 @@
+package imd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteDiffReport(t *testing.T) {
+	a, _ := sssdImage(t)
+	b, _ := sssdImage(t)
+	b.Deskew()
+
+	var report strings.Builder
+	if err := WriteDiffReport(&report, a, b); err != nil {
+		t.Fatal(err)
+	}
+	if want := "images match: 77 tracks compared\n"; report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+
+	b.Tracks[5].SectorDataRecords[b.Tracks[5].sectorIndex(3)][0] ^= 0xFF
+	b.Tracks[6].SectorRecordTypes[0] = RecordDeleted
+	b.Tracks = b.Tracks[:76]
+
+	report.Reset()
+	if err := WriteDiffReport(&report, a, b); err != nil {
+		t.Fatal(err)
+	}
+	want := "geometry: cylinders 77 vs 76\n" +
+		"track 5/0: sector 3 data differs\n" +
+		"track 6/0: sector 1 record type 1 vs 3\n" +
+		"track 76/0: only in first image\n" +
+		"3 of 77 tracks differ, 2 sectors differ\n"
+	if report.String() != want {
+		t.Errorf("report = %q, want %q", report.String(), want)
+	}
+}
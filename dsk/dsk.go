@@ -0,0 +1,201 @@
+// Package dsk converts CPCEMU DSK images, standard and extended, to IMD.
+package dsk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"imd"
+)
+
+const (
+	diskInfoSize  = 0x100
+	trackInfoSize = 0x100
+)
+
+// FDC status bits recorded per sector.
+const (
+	st1DataError     = 0x20
+	st1NoData        = 0x04
+	st2ControlMark   = 0x40
+	st2DataCRCError  = 0x20
+	st2NoAddressMark = 0x01
+)
+
+// DecodeDSK reads a standard or extended DSK image and converts it to an IMD
+// File, one IMD track per DSK track in the same order.
+//
+// Sectors keep their ID fields; cylinder and head maps are added to a track
+// whenever its sector IDs don't match its position. FDC status bytes are
+// translated to record types: a control mark makes a sector deleted, a data
+// CRC error makes it an error sector, and missing data makes it unavailable.
+// IMD stores every sector of a track at the track's size, so longer sector
+// data, such as the multiple copies of a weak sector, is cut to that size and
+// shorter data is padded with the track's filler byte and marked as an error.
+// Unformatted tracks become tracks flagged Unformatted.
+//
+// The returned File's header carries the current time, as the reference
+// tool's would.
+func DecodeDSK(r io.Reader) (imd.File, error) {
+	var file imd.File
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return file, err
+	}
+	if len(data) < diskInfoSize {
+		return file, errors.New("image too small for a disk information block")
+	}
+
+	var extended bool
+	switch {
+	case bytes.HasPrefix(data, []byte("EXTENDED CPC DSK File")):
+		extended = true
+	case bytes.HasPrefix(data, []byte("MV - CPC")):
+	default:
+		return file, errors.New("not a DSK image")
+	}
+
+	creator := strings.TrimRight(string(data[0x22:0x30]), "\x00 ")
+	file.SetHeaderTime(time.Now())
+	file.Comment = "\r\nConverted from DSK"
+	if creator != "" {
+		file.Comment += " created by " + creator
+	}
+	file.Comment += "\r\n"
+
+	tracks, sides := int(data[0x30]), int(data[0x31])
+	if sides < 1 || sides > 2 {
+		return file, fmt.Errorf("invalid number of sides %d", sides)
+	}
+
+	off := diskInfoSize
+	for i := 0; i < tracks*sides; i++ {
+		cylinder, head := byte(i/sides), byte(i%sides)
+
+		size := int(binary.LittleEndian.Uint16(data[0x32:]))
+		if extended {
+			if 0x34+i >= diskInfoSize {
+				return file, errors.New("too many tracks for the track size table")
+			}
+			size = int(data[0x34+i]) * 256
+		}
+		if size == 0 {
+			file.Tracks = append(file.Tracks, imd.Track{ModeValue: 5, Cylinder: cylinder, Head: head, Unformatted: true})
+			continue
+		}
+		if off+size > len(data) {
+			return file, fmt.Errorf("track %d/%d: image ends inside the track", cylinder, head)
+		}
+
+		t, err := decodeTrack(data[off:off+size], cylinder, head, extended)
+		if err != nil {
+			return file, fmt.Errorf("track %d/%d: %w", cylinder, head, err)
+		}
+		file.Tracks = append(file.Tracks, t)
+		off += size
+	}
+
+	return file, nil
+}
+
+// decodeTrack converts the track information block and sector data in b.
+func decodeTrack(b []byte, cylinder, head byte, extended bool) (imd.Track, error) {
+	if len(b) < trackInfoSize || !bytes.HasPrefix(b, []byte("Track-Info")) {
+		return imd.Track{}, errors.New("missing track information block")
+	}
+
+	t := imd.Track{
+		ModeValue:       mode(b[0x12], b[0x13], extended),
+		Cylinder:        cylinder,
+		Head:            head,
+		NumberOfSectors: b[0x15],
+		SectorSize:      b[0x14],
+	}
+	if t.NumberOfSectors == 0 {
+		t.Unformatted = true
+		return t, nil
+	}
+	if int(t.NumberOfSectors) > (trackInfoSize-0x18)/8 {
+		return t, fmt.Errorf("%d sectors do not fit the sector information list", t.NumberOfSectors)
+	}
+	filler := b[0x17]
+	size := imd.SectorSizeBytes(t.SectorSize)
+	if _, err := imd.SectorSizeCode(size); err != nil {
+		return t, err
+	}
+
+	n := int(t.NumberOfSectors)
+	t.SectorNumberingMap = make([]byte, n)
+	t.SectorRecordTypes = make([]byte, n)
+	t.SectorDataRecords = make([][]byte, n)
+	cylinders := make([]byte, n)
+	heads := make([]byte, n)
+	var foreignCylinder, foreignHead bool
+
+	data := b[trackInfoSize:]
+	for i := range n {
+		info := b[0x18+i*8 : 0x20+i*8]
+		cylinders[i], heads[i], t.SectorNumberingMap[i] = info[0], info[1], info[2]
+		foreignCylinder = foreignCylinder || info[0] != cylinder
+		foreignHead = foreignHead || info[1] != head
+		st1, st2 := info[4], info[5]
+
+		length := size
+		if extended {
+			length = int(binary.LittleEndian.Uint16(info[6:]))
+		}
+		if length > len(data) {
+			return t, fmt.Errorf("sector %d runs past the end of the track", t.SectorNumberingMap[i])
+		}
+		sector := data[:length]
+		data = data[length:]
+
+		if st1&st1NoData != 0 || st2&st2NoAddressMark != 0 || length == 0 {
+			t.SectorRecordTypes[i] = imd.RecordUnavailable
+			continue
+		}
+
+		record := byte(imd.RecordNormal)
+		if st2&st2ControlMark != 0 {
+			record = imd.RecordDeleted
+		}
+		if st1&st1DataError != 0 || st2&st2DataCRCError != 0 || length < size {
+			record += imd.RecordError - imd.RecordNormal
+		}
+		t.SectorRecordTypes[i] = record
+
+		t.SectorDataRecords[i] = bytes.Repeat([]byte{filler}, size)
+		copy(t.SectorDataRecords[i], sector)
+	}
+
+	if foreignCylinder {
+		t.SectorCylinderMap = cylinders
+	}
+	if foreignHead {
+		t.SectorHeadMap = heads
+	}
+	return t, nil
+}
+
+// mode returns the IMD mode for the data rate and recording mode of an
+// extended DSK track. Both are often left unknown, in which case the double
+// density MFM of CPC disks is assumed.
+func mode(rate, recording byte, extended bool) byte {
+	if !extended {
+		return 5
+	}
+	var m byte = 2 // 250 kbps
+	if rate == 2 || rate == 3 {
+		m = 0 // 500 kbps
+	}
+	if recording != 1 {
+		m += 3 // MFM
+	}
+	return m
+}
@@ -0,0 +1,121 @@
+package dsk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"imd"
+)
+
+type sector struct {
+	c, h, r, n byte
+	st1, st2   byte
+	data       []byte
+}
+
+// trackBlock returns a track information block followed by the sector data.
+func trackBlock(track, side byte, sectors []sector) []byte {
+	b := make([]byte, 0x100)
+	copy(b, "Track-Info\r\n")
+	b[0x10], b[0x11] = track, side
+	b[0x14], b[0x15], b[0x17] = 2, byte(len(sectors)), 0xE5
+	for i, s := range sectors {
+		info := b[0x18+i*8:]
+		info[0], info[1], info[2], info[3], info[4], info[5] = s.c, s.h, s.r, s.n, s.st1, s.st2
+		binary.LittleEndian.PutUint16(info[6:], uint16(len(s.data)))
+	}
+	for _, s := range sectors {
+		b = append(b, s.data...)
+	}
+	// Track blocks are stored in multiples of 256 bytes.
+	return append(b, make([]byte, (256-len(b)%256)%256)...)
+}
+
+func diskInfo(magic string, tracks, sides byte) []byte {
+	b := make([]byte, 0x100)
+	copy(b, magic)
+	copy(b[0x22:], "test")
+	b[0x30], b[0x31] = tracks, sides
+	return b
+}
+
+func TestDecodeExtendedDSK(t *testing.T) {
+	weak := append(bytes.Repeat([]byte{1}, 512), bytes.Repeat([]byte{2}, 512)...)
+	track := trackBlock(0, 0, []sector{
+		{0, 0, 0xC1, 2, 0, 0, bytes.Repeat([]byte{0xAA}, 512)},
+		{0, 0, 0xC2, 2, 0, st2ControlMark, bytes.Repeat([]byte{0xBB}, 512)},
+		{0, 0, 0xC3, 2, st1DataError, st2DataCRCError, weak},
+		{0, 0, 0xC4, 2, st1NoData, 0, nil},
+		{5, 0, 0xC5, 2, 0, 0, bytes.Repeat([]byte{0xCC}, 100)},
+	})
+
+	image := diskInfo("EXTENDED CPC DSK File\r\nDisk-Info\r\n", 2, 1)
+	image[0x34] = byte(len(track) / 256)
+	image = append(image, track...)
+
+	file, err := DecodeDSK(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Tracks) != 2 || !file.Tracks[1].Unformatted {
+		t.Fatalf("got %d tracks, want a formatted and an unformatted one", len(file.Tracks))
+	}
+
+	got := file.Tracks[0]
+	if got.ModeValue != 5 || got.NumberOfSectors != 5 || got.SectorSize != 2 {
+		t.Errorf("track = mode %d, %d sectors, size %d", got.ModeValue, got.NumberOfSectors, got.SectorSize)
+	}
+	if !bytes.Equal(got.SectorNumberingMap, []byte{0xC1, 0xC2, 0xC3, 0xC4, 0xC5}) {
+		t.Errorf("numbering map = %x", got.SectorNumberingMap)
+	}
+	if !bytes.Equal(got.SectorCylinderMap, []byte{0, 0, 0, 0, 5}) || got.SectorHeadMap != nil {
+		t.Errorf("maps = %v, %v", got.SectorCylinderMap, got.SectorHeadMap)
+	}
+	wantRecords := []byte{imd.RecordNormal, imd.RecordDeleted, imd.RecordError, imd.RecordUnavailable, imd.RecordError}
+	if !bytes.Equal(got.SectorRecordTypes, wantRecords) {
+		t.Errorf("record types = %v, want %v", got.SectorRecordTypes, wantRecords)
+	}
+	if !bytes.Equal(got.SectorDataRecords[2], weak[:512]) {
+		t.Error("weak sector not cut to the first copy")
+	}
+	if short := got.SectorDataRecords[4]; !bytes.Equal(short[:100], bytes.Repeat([]byte{0xCC}, 100)) || short[511] != 0xE5 {
+		t.Error("short sector not padded with the filler")
+	}
+
+	if err := imd.Encode(io.Discard, file); err != nil {
+		t.Errorf("converted image does not encode: %v", err)
+	}
+}
+
+func TestDecodeStandardDSK(t *testing.T) {
+	image := diskInfo("MV - CPCEMU Disk-File\r\nDisk-Info\r\n", 1, 2)
+	for side := range byte(2) {
+		var sectors []sector
+		for r := range byte(9) {
+			sectors = append(sectors, sector{0, side, 0xC1 + r, 2, 0, 0, bytes.Repeat([]byte{r}, 512)})
+		}
+		track := trackBlock(0, side, sectors)
+		binary.LittleEndian.PutUint16(image[0x32:], uint16(len(track)))
+		image = append(image, track...)
+	}
+
+	file, err := DecodeDSK(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := file.Geometry(); g.Cylinders != 1 || g.Heads != 2 || g.SectorsPerTrack != 9 || g.SectorSize != 512 {
+		t.Errorf("geometry = %+v", g)
+	}
+	if data := file.Tracks[1].SectorDataRecords[8]; !bytes.Equal(data, bytes.Repeat([]byte{8}, 512)) {
+		t.Error("wrong sector data")
+	}
+
+	if _, err := DecodeDSK(bytes.NewReader(image[:0x180])); err == nil {
+		t.Error("truncated image accepted")
+	}
+	if _, err := DecodeDSK(bytes.NewReader(make([]byte, 0x200))); err == nil {
+		t.Error("garbage accepted")
+	}
+}
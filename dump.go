@@ -0,0 +1,41 @@
+package imd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Dump writes a deterministic, human-readable representation of f to w.
+// The header timestamp is left out so dumps of the same content can be diffed.
+func (f File) Dump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	if len(f.Header) >= 8 {
+		fmt.Fprintf(bw, "version %s\n", f.Header.Version())
+	}
+	fmt.Fprintf(bw, "comment %q\n", f.Comment)
+
+	for _, t := range f.Tracks {
+		fmt.Fprintf(bw, "\ntrack cylinder=%d head=%d mode=%d sectors=%d size=%d\n",
+			t.Cylinder, t.headNumber(), t.ModeValue, t.NumberOfSectors, t.SectorSize)
+
+		for i, n := range t.SectorNumberingMap {
+			fmt.Fprintf(bw, "sector %d physical=%d type=%d", n, i, t.recordType(i))
+			if i < len(t.SectorCylinderMap) {
+				fmt.Fprintf(bw, " cylinder=%d", t.SectorCylinderMap[i])
+			}
+			if i < len(t.SectorHeadMap) {
+				fmt.Fprintf(bw, " head=%d", t.SectorHeadMap[i])
+			}
+			fmt.Fprintln(bw)
+
+			if i < len(t.SectorDataRecords) && t.SectorDataRecords[i] != nil {
+				bw.WriteString(hex.Dump(t.SectorDataRecords[i]))
+			}
+		}
+	}
+
+	return bw.Flush()
+}
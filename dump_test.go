@@ -0,0 +1,40 @@
+package imd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	file := File{
+		Header:  Header("IMD 1.18: 17/10/2014 23:41:07"),
+		Comment: "test",
+		Tracks: []Track{{
+			ModeValue:          5,
+			NumberOfSectors:    2,
+			SectorSize:         0,
+			SectorNumberingMap: []byte{2, 1},
+			SectorRecordTypes:  []byte{RecordUnavailable, RecordCompressed},
+			SectorDataRecords:  [][]byte{nil, bytes.Repeat([]byte{0xE5}, 128)},
+		}},
+	}
+
+	var a, b bytes.Buffer
+	if err := file.Dump(&a); err != nil {
+		t.Fatal(err)
+	}
+	file.Header = Header("IMD 1.18: 01/01/2000 00:00:00")
+	if err := file.Dump(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.String() != b.String() {
+		t.Fatal("dump depends on the header timestamp")
+	}
+	for _, want := range []string{"version 1.18", "sector 2 physical=0 type=0", "sector 1 physical=1 type=2", "e5 e5 e5"} {
+		if !strings.Contains(a.String(), want) {
+			t.Errorf("dump is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	attrVolumeLabel = 0x08
+	attrDirectory   = 0x10
+	attrLongName    = 0x0F
+)
+
+type dirEntry struct {
+	name    string
+	attr    byte
+	modTime time.Time
+	cluster int
+	size    int64
+}
+
+func (e *dirEntry) Name() string               { return e.name }
+func (e *dirEntry) Size() int64                { return e.size }
+func (e *dirEntry) ModTime() time.Time         { return e.modTime }
+func (e *dirEntry) IsDir() bool                { return e.attr&attrDirectory != 0 }
+func (e *dirEntry) Sys() any                   { return nil }
+func (e *dirEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *dirEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+func (e *dirEntry) Mode() fs.FileMode {
+	if e.IsDir() {
+		return fs.ModeDir | 0o555
+	}
+	return 0o444
+}
+
+// parseDirEntry parses a 32-byte directory entry. Timestamps are interpreted
+// in the local time zone, as FAT stores them without one.
+func parseDirEntry(b []byte) dirEntry {
+	name := strings.TrimRight(string(b[0:8]), " ")
+	if name != "" && name[0] == 0x05 {
+		name = "\xe5" + name[1:]
+	}
+	if ext := strings.TrimRight(string(b[8:11]), " "); ext != "" {
+		name += "." + ext
+	}
+
+	t := binary.LittleEndian.Uint16(b[22:])
+	d := binary.LittleEndian.Uint16(b[24:])
+
+	return dirEntry{
+		name: name,
+		attr: b[11],
+		modTime: time.Date(1980+int(d>>9), time.Month(d>>5&0xF), int(d&0x1F),
+			int(t>>11), int(t>>5&0x3F), int(t&0x1F)*2, 0, time.Local),
+		cluster: int(binary.LittleEndian.Uint16(b[26:])),
+		size:    int64(binary.LittleEndian.Uint32(b[28:])),
+	}
+}
+
+func (f *FAT) isEnd(entry int) bool {
+	if f.fat16 {
+		return entry >= 0xFFF8
+	}
+	return entry >= 0xFF8
+}
+
+// chain returns the clusters of the chain starting at cluster.
+func (f *FAT) chain(cluster int) ([]int, error) {
+	var clusters []int
+	for !f.isEnd(cluster) {
+		if cluster < 2 || cluster >= f.clusters+2 {
+			return nil, errors.New("cluster chain points outside the data area")
+		}
+		if len(clusters) > f.clusters {
+			return nil, errors.New("cluster chain loops")
+		}
+		clusters = append(clusters, cluster)
+		cluster = f.entry(cluster)
+	}
+	return clusters, nil
+}
+
+// readChain returns the contents of the chain starting at cluster.
+func (f *FAT) readChain(cluster int) ([]byte, error) {
+	clusters, err := f.chain(cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	size := f.clusterSize()
+	data := make([]byte, 0, len(clusters)*size)
+	for _, c := range clusters {
+		start := (f.dataStart() + (c-2)*f.sectorsPerCluster) * f.bytesPerSector
+		data = append(data, f.image[start:start+size]...)
+	}
+	return data, nil
+}
+
+// readDir returns the entries of the directory at cluster, or of the root
+// directory when cluster is 0, leaving out volume labels, long name entries,
+// deleted entries and the dot entries.
+func (f *FAT) readDir(cluster int) ([]dirEntry, error) {
+	var raw []byte
+	if cluster == 0 {
+		start := f.rootStart() * f.bytesPerSector
+		raw = f.image[start : start+f.rootEntries*32]
+	} else {
+		var err error
+		if raw, err = f.readChain(cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []dirEntry
+	for ; len(raw) >= 32; raw = raw[32:] {
+		switch {
+		case raw[0] == 0x00:
+			return entries, nil
+		case raw[0] == 0xE5, raw[11] == attrLongName, raw[11]&attrVolumeLabel != 0:
+			continue
+		}
+		e := parseDirEntry(raw)
+		if e.name == "." || e.name == ".." {
+			continue
+		}
+		if !validName(e.name) {
+			return nil, errors.New("invalid file name " + e.name)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// lookup resolves a slash-separated path, matching names case-insensitively.
+func (f *FAT) lookup(op, name string) (dirEntry, error) {
+	current := dirEntry{name: ".", attr: attrDirectory}
+	if !fs.ValidPath(name) {
+		return current, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return current, nil
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if !current.IsDir() {
+			return current, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		entries, err := f.readDir(current.cluster)
+		if err != nil {
+			return current, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		found := false
+		for _, e := range entries {
+			if strings.EqualFold(e.name, part) {
+				current, found = e, true
+				break
+			}
+		}
+		if !found {
+			return current, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+	}
+	return current, nil
+}
+
+// Open opens the named file or directory. Names are slash-separated and
+// matched case-insensitively.
+func (f *FAT) Open(name string) (fs.File, error) {
+	e, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir() {
+		entries, err := f.ReadDir(name)
+		if err != nil {
+			return nil, err
+		}
+		return &dir{entry: e, entries: entries}, nil
+	}
+
+	data, err := f.readFile(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{entry: e, Reader: bytes.NewReader(data)}, nil
+}
+
+// ReadDir returns the entries of the named directory sorted by name.
+func (f *FAT) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := f.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	raw, err := f.readDir(e.cluster)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	entries := make([]fs.DirEntry, len(raw))
+	for i := range raw {
+		entries[i] = &raw[i]
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+// ReadFile returns the contents of the named file, reading only its own
+// clusters. Names are slash-separated paths matched without regard to case,
+// such as "DOCS/NOTES.TXT". ReadFile makes FAT an fs.ReadFileFS.
+func (f *FAT) ReadFile(name string) ([]byte, error) {
+	e, err := f.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	if e.IsDir() {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+
+	data, err := f.readFile(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// readFile returns the contents of the file described by e.
+func (f *FAT) readFile(e dirEntry) ([]byte, error) {
+	if e.size == 0 {
+		return nil, nil
+	}
+	data, err := f.readChain(e.cluster)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) < e.size {
+		return nil, errors.New("cluster chain shorter than file size")
+	}
+	return data[:e.size], nil
+}
+
+type file struct {
+	entry dirEntry
+	*bytes.Reader
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return &f.entry, nil }
+func (f *file) Close() error               { return nil }
+
+type dir struct {
+	entry   dirEntry
+	entries []fs.DirEntry
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return &d.entry, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
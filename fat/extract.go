@@ -0,0 +1,71 @@
+package fat
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// ExtractAll writes every file and directory of the filesystem below
+// destDir, keeping their names and modification times. It carries on past
+// individual failures and returns them joined.
+func (f *FAT) ExtractAll(destDir string) error {
+	var errs []error
+	var dirs []string
+	times := map[string]time.Time{}
+
+	err := fs.WalkDir(f, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(name))
+		if d.IsDir() {
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				errs = append(errs, err)
+				return fs.SkipDir
+			}
+			if name != "." {
+				dirs = append(dirs, target)
+				times[target] = info.ModTime()
+			}
+			return nil
+		}
+
+		data, err := fs.ReadFile(f, name)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if err := os.WriteFile(target, data, 0o644); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+			errs = append(errs, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	// Directory times are set last, deepest first, since writing their
+	// contents updates them.
+	for _, dir := range slices.Backward(dirs) {
+		if err := os.Chtimes(dir, times[dir], times[dir]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
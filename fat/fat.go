@@ -0,0 +1,178 @@
+// Package fat reads FAT12 and FAT16 filesystems stored in IMD images.
+package fat
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"imd"
+)
+
+// extendedBootSignature marks a boot sector carrying a serial number, volume
+// label and filesystem type after the BPB.
+const extendedBootSignature = 0x29
+
+type FAT struct {
+	image []byte
+
+	bytesPerSector,
+	sectorsPerCluster,
+	reservedSectors,
+	numberOfFATs,
+	rootEntries,
+	totalSectors,
+	sectorsPerFAT int
+
+	clusters int
+	fat16    bool
+}
+
+// New parses the FAT filesystem in file. The tracks of file must be in cylinder and
+// head order, as they are in images written by the reference tool.
+func New(file imd.File) (*FAT, error) {
+	image, err := file.RawImage(imd.RawImageOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if len(image) < 512 {
+		return nil, errors.New("image too small for a boot sector")
+	}
+
+	f := &FAT{
+		image:             image,
+		bytesPerSector:    int(binary.LittleEndian.Uint16(image[11:])),
+		sectorsPerCluster: int(image[13]),
+		reservedSectors:   int(binary.LittleEndian.Uint16(image[14:])),
+		numberOfFATs:      int(image[16]),
+		rootEntries:       int(binary.LittleEndian.Uint16(image[17:])),
+		totalSectors:      int(binary.LittleEndian.Uint16(image[19:])),
+		sectorsPerFAT:     int(binary.LittleEndian.Uint16(image[22:])),
+	}
+	if f.totalSectors == 0 {
+		f.totalSectors = int(binary.LittleEndian.Uint32(image[32:]))
+	}
+
+	switch f.bytesPerSector {
+	case 128, 256, 512, 1024, 2048, 4096:
+	default:
+		return nil, errors.New("invalid bytes per sector")
+	}
+	if f.sectorsPerCluster == 0 || f.sectorsPerCluster&(f.sectorsPerCluster-1) != 0 {
+		return nil, errors.New("invalid sectors per cluster")
+	}
+	if f.numberOfFATs == 0 || f.sectorsPerFAT == 0 {
+		return nil, errors.New("no allocation table")
+	}
+	if f.dataStart() >= f.totalSectors || f.totalSectors*f.bytesPerSector > len(image) {
+		return nil, errors.New("filesystem does not fit the image")
+	}
+
+	f.clusters = (f.totalSectors - f.dataStart()) / f.sectorsPerCluster
+	f.fat16 = f.clusters >= 4085
+	if f.clusters+2 > f.maxEntries() {
+		return nil, errors.New("allocation table too small for the data area")
+	}
+
+	return f, nil
+}
+
+func (f *FAT) rootStart() int {
+	return f.reservedSectors + f.numberOfFATs*f.sectorsPerFAT
+}
+
+func (f *FAT) dataStart() int {
+	return f.rootStart() + (f.rootEntries*32+f.bytesPerSector-1)/f.bytesPerSector
+}
+
+func (f *FAT) clusterSize() int {
+	return f.sectorsPerCluster * f.bytesPerSector
+}
+
+func (f *FAT) maxEntries() int {
+	if f.fat16 {
+		return f.sectorsPerFAT * f.bytesPerSector / 2
+	}
+	return f.sectorsPerFAT * f.bytesPerSector * 2 / 3
+}
+
+// entry returns the first allocation table's entry for cluster.
+func (f *FAT) entry(cluster int) int {
+	table := f.image[f.reservedSectors*f.bytesPerSector:]
+	if f.fat16 {
+		return int(binary.LittleEndian.Uint16(table[cluster*2:]))
+	}
+
+	v := int(binary.LittleEndian.Uint16(table[cluster*3/2:]))
+	if cluster%2 == 1 {
+		return v >> 4
+	}
+	return v & 0xFFF
+}
+
+func (f *FAT) isBad(entry int) bool {
+	if f.fat16 {
+		return entry == 0xFFF7
+	}
+	return entry == 0xFF7
+}
+
+// Usage returns the number of bytes in allocated and in free clusters. Clusters
+// marked bad count as neither.
+func (f *FAT) Usage() (used, free int64, err error) {
+	for cluster := 2; cluster < f.clusters+2; cluster++ {
+		switch entry := f.entry(cluster); {
+		case entry == 0:
+			free++
+		case !f.isBad(entry):
+			used++
+		}
+	}
+
+	size := int64(f.clusterSize())
+	return used * size, free * size, nil
+}
+
+// IsEmpty reports whether no cluster is allocated and the root directory
+// holds nothing but a volume label, as on a freshly formatted disk.
+func (f *FAT) IsEmpty() bool {
+	used, _, _ := f.Usage()
+	if used > 0 {
+		return false
+	}
+	entries, err := f.ReadDir(".")
+	return err == nil && len(entries) == 0
+}
+
+// IsBlank reports whether file is blank, either with uniform content as
+// reported by File.IsBlank or with an empty FAT filesystem.
+func IsBlank(file imd.File) bool {
+	if file.IsBlank() {
+		return true
+	}
+	f, err := New(file)
+	return err == nil && f.IsEmpty()
+}
+
+// VolumeLabel returns the volume label of the filesystem, taken from the
+// label entry of the root directory or, when there is none, from the
+// extended boot record. It returns an empty label if the disk has none;
+// DOS writes "NO NAME" to the boot sector of unlabelled disks.
+func (f *FAT) VolumeLabel() (string, error) {
+	start := f.rootStart() * f.bytesPerSector
+	root := f.image[start : start+f.rootEntries*32]
+	for ; len(root) >= 32 && root[0] != 0x00; root = root[32:] {
+		if root[0] != 0xE5 && root[11] != attrLongName && root[11]&attrVolumeLabel != 0 {
+			return strings.TrimRight(string(root[:11]), " "), nil
+		}
+	}
+
+	if f.image[38] != extendedBootSignature {
+		return "", nil
+	}
+	label := strings.TrimRight(string(f.image[43:54]), " ")
+	if label == "NO NAME" {
+		label = ""
+	}
+	return label, nil
+}
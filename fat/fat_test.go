@@ -0,0 +1,253 @@
+package fat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"imd"
+)
+
+// newImage returns a blank 1.44M FAT12 image as a raw sector image.
+func newImage() []byte {
+	image := make([]byte, 2880*512)
+	copy(image[3:], "MSDOS5.0")
+	binary.LittleEndian.PutUint16(image[11:], 512)
+	image[13] = 1
+	binary.LittleEndian.PutUint16(image[14:], 1)
+	image[16] = 2
+	binary.LittleEndian.PutUint16(image[17:], 224)
+	binary.LittleEndian.PutUint16(image[19:], 2880)
+	image[21] = 0xF0
+	binary.LittleEndian.PutUint16(image[22:], 9)
+	binary.LittleEndian.PutUint16(image[24:], 18)
+	binary.LittleEndian.PutUint16(image[26:], 2)
+	image[510], image[511] = 0x55, 0xAA
+
+	for _, fat := range []int{1, 10} {
+		copy(image[fat*512:], []byte{0xF0, 0xFF, 0xFF})
+	}
+	return image
+}
+
+// setEntry sets a FAT12 entry in both allocation tables of image.
+func setEntry(image []byte, cluster, value int) {
+	for _, fat := range []int{1, 10} {
+		p := image[fat*512+cluster*3/2:]
+		v := binary.LittleEndian.Uint16(p)
+		if cluster%2 == 1 {
+			v = v&0x000F | uint16(value)<<4
+		} else {
+			v = v&0xF000 | uint16(value)
+		}
+		binary.LittleEndian.PutUint16(p, v)
+	}
+}
+
+func open(t *testing.T, image []byte) *FAT {
+	t.Helper()
+
+	g, _ := imd.GuessGeometry(len(image))
+	file, err := imd.FromRawImage(image, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := New(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+func TestUsage(t *testing.T) {
+	image := newImage()
+	setEntry(image, 2, 3)
+	setEntry(image, 3, 0xFFF)
+	setEntry(image, 4, 0xFF7)
+	setEntry(image, 5, 0xFFF)
+
+	used, free, err := open(t, image).Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != 3*512 {
+		t.Errorf("used = %d, want %d", used, 3*512)
+	}
+	if want := int64(2847-4) * 512; free != want {
+		t.Errorf("free = %d, want %d", free, want)
+	}
+}
+
+func TestNewRejectsGarbage(t *testing.T) {
+	file, err := imd.FromRawImage(make([]byte, 2880*512), imd.Geometry{Cylinders: 80, Heads: 2, SectorsPerTrack: 18, SectorSize: 512, SectorBase: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(file); err == nil {
+		t.Error("New accepted an image without a BPB")
+	}
+}
+
+// putEntry writes a directory entry at byte offset off of image.
+func putEntry(image []byte, off int, name string, attr byte, cluster, size int, modTime time.Time) {
+	copy(image[off:off+11], fmt.Sprintf("%-11s", name))
+	image[off+11] = attr
+	binary.LittleEndian.PutUint16(image[off+22:], uint16(modTime.Hour()<<11|modTime.Minute()<<5|modTime.Second()/2))
+	binary.LittleEndian.PutUint16(image[off+24:], uint16((modTime.Year()-1980)<<9|int(modTime.Month())<<5|modTime.Day()))
+	binary.LittleEndian.PutUint16(image[off+26:], uint16(cluster))
+	binary.LittleEndian.PutUint32(image[off+28:], uint32(size))
+}
+
+var (
+	readmeTime = time.Date(1993, 5, 17, 14, 30, 10, 0, time.Local)
+	notesTime  = time.Date(1994, 1, 2, 8, 0, 0, 0, time.Local)
+	docsTime   = time.Date(1993, 12, 31, 23, 59, 58, 0, time.Local)
+)
+
+// newFilesImage returns a FAT12 image holding README.TXT spanning two
+// clusters and DOCS/NOTES.TXT, plus a volume label entry.
+func newFilesImage() []byte {
+	image := newImage()
+	const root, data = 19 * 512, 33 * 512
+
+	putEntry(image, root, "ARCHIVE", 0x08, 0, 0, readmeTime)
+	putEntry(image, root+32, "README  TXT", 0x20, 2, 600, readmeTime)
+	putEntry(image, root+64, "DOCS", 0x10, 4, 0, docsTime)
+	setEntry(image, 2, 3)
+	setEntry(image, 3, 0xFFF)
+	setEntry(image, 4, 0xFFF)
+	setEntry(image, 5, 0xFFF)
+	for i := range 600 {
+		image[data+i] = byte('a' + i%26)
+	}
+
+	docs := data + 2*512
+	putEntry(image, docs, ".", 0x10, 4, 0, docsTime)
+	putEntry(image, docs+32, "..", 0x10, 0, 0, docsTime)
+	putEntry(image, docs+64, "NOTES   TXT", 0x20, 5, 5, notesTime)
+	copy(image[data+3*512:], "notes")
+
+	return image
+}
+
+func TestExtractAll(t *testing.T) {
+	dest := t.TempDir()
+	if err := open(t, newFilesImage()).ExtractAll(dest); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		modTime time.Time
+	}{
+		{"README.TXT", 600, readmeTime},
+		{"DOCS/NOTES.TXT", 5, notesTime},
+		{"DOCS", -1, docsTime},
+	}
+	for _, test := range tests {
+		info, err := os.Stat(filepath.Join(dest, test.name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if test.size >= 0 && info.Size() != int64(test.size) {
+			t.Errorf("%s: size = %d, want %d", test.name, info.Size(), test.size)
+		}
+		if !info.ModTime().Equal(test.modTime) {
+			t.Errorf("%s: mod time = %v, want %v", test.name, info.ModTime(), test.modTime)
+		}
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dest, "DOCS", "NOTES.TXT"))
+	if string(data) != "notes" {
+		t.Errorf("NOTES.TXT = %q", data)
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 2 {
+		t.Errorf("extracted %d root entries, want 2", len(entries))
+	}
+}
+
+func TestFS(t *testing.T) {
+	if err := fstest.TestFS(open(t, newFilesImage()), "README.TXT", "DOCS/NOTES.TXT"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	labelled := newImage()
+	putEntry(labelled, 19*512, "ARCHIVE", 0x08, 0, 0, readmeTime)
+
+	for _, test := range []struct {
+		name  string
+		image []byte
+		blank bool
+	}{
+		{"formatted", newImage(), true},
+		{"labelled", labelled, true},
+		{"files", newFilesImage(), false},
+	} {
+		g, _ := imd.GuessGeometry(len(test.image))
+		file, err := imd.FromRawImage(test.image, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blank := IsBlank(file); blank != test.blank {
+			t.Errorf("%s: IsBlank = %v, want %v", test.name, blank, test.blank)
+		}
+	}
+
+	g, _ := imd.GuessGeometry(2880 * 512)
+	if !IsBlank(imd.NewBlankImage(g, 0xF6, "", "")) {
+		t.Error("unformatted image is not blank")
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	f := open(t, newFilesImage())
+
+	var _ fs.ReadFileFS = f
+	data, err := f.ReadFile("docs/notes.txt")
+	if err != nil || string(data) != "notes" {
+		t.Errorf("ReadFile(docs/notes.txt) = %q, %v", data, err)
+	}
+	if data, err := f.ReadFile("README.TXT"); err != nil || len(data) != 600 || data[26] != 'a' {
+		t.Errorf("ReadFile(README.TXT) = %d bytes, %v", len(data), err)
+	}
+
+	for _, name := range []string{"DOCS", "MISSING.TXT", "DOCS/MISSING.TXT", "/README.TXT"} {
+		if _, err := f.ReadFile(name); err == nil {
+			t.Errorf("ReadFile(%q): no error", name)
+		}
+	}
+}
+
+func TestVolumeLabel(t *testing.T) {
+	image := newImage()
+	if label, err := open(t, image).VolumeLabel(); label != "" || err != nil {
+		t.Errorf("VolumeLabel() = %q, %v without a label", label, err)
+	}
+
+	image[38] = extendedBootSignature
+	copy(image[43:], "NO NAME    ")
+	if label, _ := open(t, image).VolumeLabel(); label != "" {
+		t.Errorf("VolumeLabel() = %q for NO NAME", label)
+	}
+
+	copy(image[43:], "BOOT LABEL ")
+	if label, _ := open(t, image).VolumeLabel(); label != "BOOT LABEL" {
+		t.Errorf("VolumeLabel() = %q, want the boot sector label", label)
+	}
+
+	putEntry(image, 19*512, "OLD", 0x08, 0, 0, readmeTime)
+	image[19*512] = 0xE5
+	putEntry(image, 19*512+32, "DISK    ONE", 0x08, 0, 0, readmeTime)
+	if label, _ := open(t, image).VolumeLabel(); label != "DISK    ONE" {
+		t.Errorf("VolumeLabel() = %q, want the directory label", label)
+	}
+}
@@ -0,0 +1,70 @@
+package imd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const fdiHeaderSize = 4096
+
+// FDD type codes of the FDI header, as used by PC-98 emulators.
+const (
+	fdiType2DD  = 0x10 // 640K and 720K
+	fdiType1440 = 0x30 // 1.44M, 18 sectors of 512 bytes
+	fdiType2HD  = 0x90 // 1.2M and 1.25M
+)
+
+// WriteFDI writes f as an FDI image, the flat PC-98 format of the Anex86
+// emulator: a 4096-byte header giving the geometry, followed by the sector
+// data laid out as by RawImage with tracks in cylinder and head order.
+//
+// FDI can only describe uniform disks, so every track from cylinder 0 up to
+// the last must be present and formatted with the same number and size of
+// sectors. Unavailable sectors are written as zeros.
+func (f File) WriteFDI(w io.Writer) error {
+	f.SortTracks()
+	g := f.Geometry()
+	if len(f.Tracks) == 0 {
+		return errors.New("no tracks")
+	}
+	if len(f.Tracks) != g.Cylinders*g.Heads {
+		return fmt.Errorf("image has %d tracks, want %d for %d cylinders and %d heads", len(f.Tracks), g.Cylinders*g.Heads, g.Cylinders, g.Heads)
+	}
+	for i, t := range f.Tracks {
+		if int(t.Cylinder) != i/g.Heads || int(t.headNumber()) != i%g.Heads {
+			return fmt.Errorf("track %d/%d is missing", i/g.Heads, i%g.Heads)
+		}
+		if t.Unformatted || int(t.NumberOfSectors) != g.SectorsPerTrack || SectorSizeBytes(t.SectorSize) != g.SectorSize {
+			return fmt.Errorf("track %d/%d does not have %d sectors of %d bytes", t.Cylinder, t.headNumber(), g.SectorsPerTrack, g.SectorSize)
+		}
+	}
+
+	data, err := f.RawImage(RawImageOptions{})
+	if err != nil {
+		return err
+	}
+
+	var fddType uint32 = fdiType2DD
+	if mode, ok := f.Tracks[0].Mode(); ok && mode.Rate == 500 {
+		fddType = fdiType2HD
+		if g.SectorsPerTrack == 18 && g.SectorSize == 512 {
+			fddType = fdiType1440
+		}
+	}
+
+	header := make([]byte, fdiHeaderSize)
+	for i, v := range []uint32{
+		0, fddType, fdiHeaderSize, uint32(len(data)),
+		uint32(g.SectorSize), uint32(g.SectorsPerTrack), uint32(g.Heads), uint32(g.Cylinders),
+	} {
+		binary.LittleEndian.PutUint32(header[i*4:], v)
+	}
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
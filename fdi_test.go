@@ -0,0 +1,38 @@
+package imd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestWriteFDI(t *testing.T) {
+	g := Geometry{Cylinders: 77, Heads: 2, SectorsPerTrack: 8, SectorSize: 1024, ModeValue: 3, SectorBase: 1}
+	file := NewBlankImage(g, 0xE5, "", "")
+	file.Tracks[0], file.Tracks[1] = file.Tracks[1], file.Tracks[0]
+	copy(file.Tracks[0].SectorDataRecords[0], "head one")
+
+	var buf bytes.Buffer
+	if err := file.WriteFDI(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+	if len(out) != 4096+77*2*8*1024 {
+		t.Fatalf("wrote %d bytes", len(out))
+	}
+	var fields [8]uint32
+	for i := range fields {
+		fields[i] = binary.LittleEndian.Uint32(out[i*4:])
+	}
+	if fields != [8]uint32{0, fdiType2HD, 4096, 77 * 2 * 8 * 1024, 1024, 8, 2, 77} {
+		t.Errorf("header fields = %v", fields)
+	}
+	if !bytes.HasPrefix(out[4096+8*1024:], []byte("head one")) {
+		t.Error("tracks not written in cylinder and head order")
+	}
+
+	file.Tracks = file.Tracks[1:]
+	if err := file.WriteFDI(&buf); err == nil {
+		t.Error("image with a missing track accepted")
+	}
+}
@@ -0,0 +1,312 @@
+package imd
+
+import (
+	"crypto/md5"
+	"fmt"
+	"slices"
+)
+
+// TrimEmptyTracks removes the trailing tracks in which every sector is
+// unavailable and returns how many were removed.
+func (f *File) TrimEmptyTracks() int {
+	n := len(f.Tracks)
+	for n > 0 && f.Tracks[n-1].isEmpty() {
+		n--
+	}
+
+	removed := len(f.Tracks) - n
+	f.Tracks = f.Tracks[:n]
+	return removed
+}
+
+// Track returns the first track of f with the given cylinder and head, or nil
+// if there is none. The returned track aliases f.Tracks.
+func (f File) Track(cylinder, head byte) *Track {
+	for i := range f.Tracks {
+		if f.Tracks[i].Cylinder == cylinder && f.Tracks[i].headNumber() == head {
+			return &f.Tracks[i]
+		}
+	}
+	return nil
+}
+
+// CopySector copies a sector, including its record type, from src into the
+// matching track of dst. If dst lacks the track or the sector, CopySector
+// adds it when create is set and fails otherwise.
+func CopySector(dst *File, src File, cylinder, head, sector byte, create bool) error {
+	from := src.Track(cylinder, head)
+	if from == nil {
+		return fmt.Errorf("source has no track %d/%d", cylinder, head)
+	}
+	i := from.sectorIndex(sector)
+	if i < 0 {
+		return fmt.Errorf("source track %d/%d has no sector %d", cylinder, head, sector)
+	}
+
+	record := from.recordType(i)
+	var data []byte
+	if record != RecordUnavailable {
+		data = slices.Clone(from.SectorDataRecords[i])
+	}
+
+	to := dst.Track(cylinder, head)
+	if to == nil {
+		if !create {
+			return fmt.Errorf("destination has no track %d/%d", cylinder, head)
+		}
+		dst.Tracks = append(dst.Tracks, Track{
+			ModeValue:  from.ModeValue,
+			Cylinder:   cylinder,
+			Head:       head,
+			SectorSize: from.SectorSize,
+		})
+		to = &dst.Tracks[len(dst.Tracks)-1]
+	}
+	if to.SectorSize != from.SectorSize {
+		return fmt.Errorf("track %d/%d: sector sizes differ", cylinder, head)
+	}
+
+	j := to.sectorIndex(sector)
+	if j < 0 {
+		if !create {
+			return fmt.Errorf("destination track %d/%d has no sector %d", cylinder, head, sector)
+		}
+		if to.NumberOfSectors == 0xFF {
+			return fmt.Errorf("destination track %d/%d is full", cylinder, head)
+		}
+		to.appendSector(sector, record, data)
+		return nil
+	}
+
+	to.fillRecords()
+	to.SectorRecordTypes[j] = record
+	to.SectorDataRecords[j] = data
+	return nil
+}
+
+// SidesIdentical reports whether f is double-sided with every head 1 track
+// holding the same content as the head 0 track of its cylinder, as happens
+// when a single-sided disk is imaged as double-sided.
+func (f File) SidesIdentical() bool {
+	var compared bool
+	for _, t := range f.Tracks {
+		if t.headNumber() != 0 {
+			continue
+		}
+		other := f.Track(t.Cylinder, 1)
+		if other == nil {
+			return false
+		}
+		if !t.EqualContent(*other) {
+			return false
+		}
+		compared = true
+	}
+	return compared
+}
+
+// TrackMap indexes f's tracks by cylinder and head for repeated lookups. As
+// with Track, the first of several tracks with the same address wins. The
+// pointers alias f.Tracks, so the map must be rebuilt after tracks are added,
+// removed or reordered.
+func (f File) TrackMap() map[[2]byte]*Track {
+	m := make(map[[2]byte]*Track, len(f.Tracks))
+	for i := range f.Tracks {
+		key := [2]byte{f.Tracks[i].Cylinder, f.Tracks[i].headNumber()}
+		if _, ok := m[key]; !ok {
+			m[key] = &f.Tracks[i]
+		}
+	}
+	return m
+}
+
+// SortTracks orders f's tracks by cylinder and then head, keeping the file
+// order of tracks with the same address. Offsets are reordered along with
+// the tracks.
+func (f *File) SortTracks() {
+	order := make([]int, len(f.Tracks))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		ta, tb := f.Tracks[a], f.Tracks[b]
+		if ta.Cylinder != tb.Cylinder {
+			return int(ta.Cylinder) - int(tb.Cylinder)
+		}
+		return int(ta.headNumber()) - int(tb.headNumber())
+	})
+
+	f.Tracks = permute(f.Tracks, order)
+	f.offsets = permute(f.offsets, order)
+}
+
+// ReplaceTrackFrom copies the track at cylinder/head from src into f,
+// replacing f's track at that address or appending it if f has none. The
+// track must use the same sector size and mode as the track it replaces, or,
+// when there is none, as the nearest track of f.
+func (f *File) ReplaceTrackFrom(src File, cylinder, head byte) error {
+	from := src.Track(cylinder, head)
+	if from == nil {
+		return fmt.Errorf("source has no track %d/%d", cylinder, head)
+	}
+
+	to := f.Track(cylinder, head)
+	reference := to
+	if reference == nil {
+		reference = f.nearestTrack(cylinder, head)
+	}
+	if reference != nil {
+		if from.SectorSize != reference.SectorSize {
+			return fmt.Errorf("track %d/%d: sector size code %d does not match %d of track %d/%d",
+				cylinder, head, from.SectorSize, reference.SectorSize, reference.Cylinder, reference.headNumber())
+		}
+		if from.ModeValue != reference.ModeValue {
+			return fmt.Errorf("track %d/%d: mode %d does not match %d of track %d/%d",
+				cylinder, head, from.ModeValue, reference.ModeValue, reference.Cylinder, reference.headNumber())
+		}
+	}
+
+	if to != nil {
+		*to = from.clone()
+	} else {
+		f.Tracks = append(f.Tracks, from.clone())
+	}
+	return nil
+}
+
+// SwapHeads exchanges heads 0 and 1 throughout f, in both the track
+// addresses and the sector head maps, for dumps whose sides were read the
+// wrong way round. Tracks keep their place in f.Tracks; use SortTracks to
+// restore the usual order.
+func (f *File) SwapHeads() {
+	for i := range f.Tracks {
+		t := &f.Tracks[i]
+		if t.headNumber() <= 1 {
+			t.Head ^= 1
+		}
+		for j, h := range t.SectorHeadMap {
+			if h <= 1 {
+				t.SectorHeadMap[j] = h ^ 1
+			}
+		}
+	}
+}
+
+// nearestTrack returns the track of f closest to cylinder/head, preferring
+// the other side of the same cylinder, or nil if f has no tracks.
+func (f File) nearestTrack(cylinder, head byte) *Track {
+	var nearest *Track
+	best := -1
+	for i := range f.Tracks {
+		t := &f.Tracks[i]
+		d := 2 * abs(int(t.Cylinder)-int(cylinder))
+		if t.headNumber() != head {
+			d++
+		}
+		if best < 0 || d < best {
+			nearest, best = t, d
+		}
+	}
+	return nearest
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// FillByteStats counts, for every byte value, the sectors of f whose data
+// consists of that byte alone, i.e. the sectors Encode stores compressed.
+// Freshly formatted disks are dominated by their format filler, usually 0xE5
+// or 0x00.
+func (f File) FillByteStats() map[byte]int {
+	stats := make(map[byte]int)
+	for _, t := range f.Tracks {
+		for i := range t.SectorNumberingMap {
+			if t.recordType(i) == RecordUnavailable {
+				continue
+			}
+			if data := t.sectorData(i); isUniform(data) {
+				stats[data[0]]++
+			}
+		}
+	}
+	return stats
+}
+
+// IsBlank reports whether f holds at least one readable sector and every
+// readable sector is filled with the same byte, as on a disk that was
+// formatted without a filesystem or never written to. Disks formatted with
+// an empty filesystem are not blank by this measure; see fat.IsBlank.
+func (f File) IsBlank() bool {
+	var fillByte byte
+	var present bool
+	for _, t := range f.Tracks {
+		for i := range t.SectorNumberingMap {
+			data := t.sectorData(i)
+			if data == nil {
+				continue
+			}
+			if !isUniform(data) || present && data[0] != fillByte {
+				return false
+			}
+			fillByte, present = data[0], true
+		}
+	}
+	return present
+}
+
+// SectorHashes returns the MD5 digest of every readable sector of f, keyed by
+// cylinder and head and then by sector number. Comparing digests across
+// images finds shared sectors without keeping their data around. Where f
+// holds a track twice, the first one is hashed, as Track would return it.
+func (f File) SectorHashes() map[[2]byte]map[byte][16]byte {
+	hashes := make(map[[2]byte]map[byte][16]byte)
+	for _, t := range f.Tracks {
+		key := [2]byte{t.Cylinder, t.headNumber()}
+		if _, ok := hashes[key]; ok {
+			continue
+		}
+		sectors := make(map[byte][16]byte)
+		for i, number := range t.SectorNumberingMap {
+			if data := t.sectorData(i); data != nil {
+				sectors[number] = md5.Sum(data)
+			}
+		}
+		hashes[key] = sectors
+	}
+	return hashes
+}
+
+// AddressedSector is a sector together with its address, as returned by
+// AllSectors.
+type AddressedSector struct {
+	Cylinder,
+	Head,
+	Sector,
+	Record byte
+
+	// Data aliases the sector data of the File, or is nil for an unavailable
+	// sector.
+	Data []byte
+}
+
+// AllSectors returns every sector of f, track by track in the order of
+// f.Tracks and in logical order within a track, as RawImage lays them out.
+func (f File) AllSectors() []AddressedSector {
+	var sectors []AddressedSector
+	for _, t := range f.Tracks {
+		for _, i := range t.logicalOrder() {
+			sectors = append(sectors, AddressedSector{
+				Cylinder: t.Cylinder,
+				Head:     t.headNumber(),
+				Sector:   t.SectorNumberingMap[i],
+				Record:   t.recordType(i),
+				Data:     t.sectorData(i),
+			})
+		}
+	}
+	return sectors
+}
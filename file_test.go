@@ -0,0 +1,250 @@
+package imd
+
+import (
+	"bytes"
+	"crypto/md5"
+	"io"
+	"maps"
+	"slices"
+	"testing"
+)
+
+func TestTrimEmptyTracks(t *testing.T) {
+	empty := Track{NumberOfSectors: 2, SectorNumberingMap: []byte{1, 2}, SectorRecordTypes: []byte{0, 0}, SectorDataRecords: make([][]byte, 2)}
+	used := Track{NumberOfSectors: 1, SectorNumberingMap: []byte{1}, SectorDataRecords: [][]byte{make([]byte, 128)}}
+
+	file := File{Tracks: []Track{used, empty, used, empty, empty}}
+	if n := file.TrimEmptyTracks(); n != 2 {
+		t.Errorf("TrimEmptyTracks() = %d, want 2", n)
+	}
+	if len(file.Tracks) != 3 {
+		t.Errorf("%d tracks left, want 3", len(file.Tracks))
+	}
+	if n := file.TrimEmptyTracks(); n != 0 {
+		t.Errorf("second TrimEmptyTracks() = %d, want 0", n)
+	}
+}
+
+func TestCopySector(t *testing.T) {
+	src, _ := sssdImage(t)
+	dst := File{Tracks: []Track{{
+		Cylinder:           0,
+		NumberOfSectors:    1,
+		SectorNumberingMap: []byte{1},
+		SectorRecordTypes:  []byte{RecordUnavailable},
+		SectorDataRecords:  [][]byte{nil},
+	}}}
+
+	if err := CopySector(&dst, src, 0, 0, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dst.Tracks[0].SectorDataRecords[0], src.Tracks[0].SectorDataRecords[src.Tracks[0].sectorIndex(1)]; !bytes.Equal(got, want) {
+		t.Error("sector 1 was not copied")
+	}
+
+	if err := CopySector(&dst, src, 0, 0, 2, false); err == nil {
+		t.Error("CopySector created a missing sector without create")
+	}
+	if err := CopySector(&dst, src, 5, 0, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	track := dst.Track(5, 0)
+	if track == nil || track.NumberOfSectors != 1 || track.SectorNumberingMap[0] != 2 {
+		t.Fatal("CopySector did not create the missing track")
+	}
+	if err := Encode(io.Discard, dst); err != nil {
+		t.Errorf("patched image does not encode: %v", err)
+	}
+}
+
+func TestSidesIdentical(t *testing.T) {
+	data := make([]byte, 2*2*9*512)
+	for i := range data {
+		data[i] = byte(i / 512 / 9 / 2)
+	}
+	file, err := FromRawImage(data, Geometry{Cylinders: 2, Heads: 2, SectorsPerTrack: 9, SectorSize: 512})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.SidesIdentical() {
+		t.Error("SidesIdentical() = false for a duplicated side")
+	}
+
+	file.Tracks[3].SectorDataRecords[4][0] ^= 0xFF
+	if file.SidesIdentical() {
+		t.Error("SidesIdentical() = true after changing head 1")
+	}
+
+	single, _ := FromRawImage(data[:2*9*512], Geometry{Cylinders: 2, Heads: 1, SectorsPerTrack: 9, SectorSize: 512})
+	if single.SidesIdentical() {
+		t.Error("SidesIdentical() = true for a single-sided image")
+	}
+}
+
+func TestTrackMap(t *testing.T) {
+	file, _ := sssdImage(t)
+	m := file.TrackMap()
+	if len(m) != len(file.Tracks) {
+		t.Fatalf("TrackMap has %d entries, want %d", len(m), len(file.Tracks))
+	}
+	for c := byte(0); c < 77; c++ {
+		if m[[2]byte{c, 0}] != file.Track(c, 0) {
+			t.Errorf("TrackMap and Track disagree on cylinder %d", c)
+		}
+	}
+}
+
+func TestSortTracks(t *testing.T) {
+	track := func(cylinder, head byte, comment byte) Track {
+		return Track{Cylinder: cylinder, Head: head | sectorHeadMapMask, ModeValue: comment}
+	}
+	file := File{
+		Tracks:  []Track{track(1, 1, 0), track(0, 1, 1), track(1, 0, 2), track(0, 0, 3), track(1, 0, 4)},
+		offsets: []TrackOffsets{{Track: 0}, {Track: 1}, {Track: 2}, {Track: 3}, {Track: 4}},
+	}
+	file.SortTracks()
+
+	want := []byte{3, 1, 2, 4, 0}
+	for i, track := range file.Tracks {
+		if track.ModeValue != want[i] || file.offsets[i].Track != int64(want[i]) {
+			t.Fatalf("track %d is %d, want %d", i, track.ModeValue, want[i])
+		}
+	}
+}
+
+func TestReplaceTrackFrom(t *testing.T) {
+	g := Geometry{Cylinders: 3, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, ModeValue: 5, SectorBase: 1}
+	donor := NewBlankImage(g, 0xAA, "", "")
+	file := NewBlankImage(g, 0xE5, "", "")
+
+	if err := file.ReplaceTrackFrom(donor, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !file.Track(1, 1).EqualContent(*donor.Track(1, 1)) {
+		t.Error("track was not replaced")
+	}
+	donor.Track(1, 1).SectorDataRecords[0][0] = 0
+	if file.Track(1, 1).SectorDataRecords[0][0] != 0xAA {
+		t.Error("replaced track aliases the donor")
+	}
+
+	file.Tracks = slices.DeleteFunc(file.Tracks, func(t Track) bool { return t.Cylinder == 2 && t.Head == 0 })
+	if err := file.ReplaceTrackFrom(donor, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Tracks) != 6 {
+		t.Errorf("missing track was not added")
+	}
+
+	donor.Track(0, 0).ModeValue = 3
+	if err := file.ReplaceTrackFrom(donor, 0, 0); err == nil {
+		t.Error("ReplaceTrackFrom accepted a different mode")
+	}
+	donor.Track(0, 0).ModeValue = 5
+	donor.Track(0, 0).SectorSize = 1
+	if err := file.ReplaceTrackFrom(donor, 0, 0); err == nil {
+		t.Error("ReplaceTrackFrom accepted a different sector size")
+	}
+}
+
+func TestFillByteStats(t *testing.T) {
+	file, _ := sssdImage(t)
+	if stats := file.FillByteStats(); !maps.Equal(stats, map[byte]int{0xE5: 10}) {
+		t.Errorf("stats = %v", stats)
+	}
+
+	blank := NewBlankImage(Geometry{Cylinders: 40, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, SectorBase: 1}, 0xF6, "", "")
+	if stats := blank.FillByteStats(); !maps.Equal(stats, map[byte]int{0xF6: 40 * 2 * 9}) {
+		t.Errorf("blank stats = %v", stats)
+	}
+}
+
+func TestIsBlank(t *testing.T) {
+	g := Geometry{Cylinders: 40, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, SectorBase: 1}
+	blank := NewBlankImage(g, 0xE5, "", "")
+	if !blank.IsBlank() {
+		t.Error("blank image is not blank")
+	}
+
+	blank.Tracks[3].SectorRecordTypes[2] = RecordUnavailable
+	if !blank.IsBlank() {
+		t.Error("unavailable sector made the image non-blank")
+	}
+
+	blank.Tracks[5].SectorDataRecords[0] = bytes.Repeat([]byte{0xF6}, 512)
+	if blank.IsBlank() {
+		t.Error("image mixing fill bytes is blank")
+	}
+
+	if file, _ := sssdImage(t); file.IsBlank() {
+		t.Error("image with data is blank")
+	}
+	if (File{}).IsBlank() {
+		t.Error("image without sectors is blank")
+	}
+}
+
+func TestSwapHeads(t *testing.T) {
+	g := Geometry{Cylinders: 2, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, SectorBase: 1}
+	file := NewBlankImage(g, 0xE5, "", "")
+	file.Tracks[1].Head |= sectorHeadMapMask
+	file.Tracks[1].SectorHeadMap = []byte{1, 1, 0, 1, 1, 1, 1, 1, 2}
+
+	file.SwapHeads()
+	var heads []byte
+	for _, track := range file.Tracks {
+		heads = append(heads, track.headNumber())
+	}
+	if !bytes.Equal(heads, []byte{1, 0, 1, 0}) {
+		t.Errorf("heads = %v", heads)
+	}
+	if file.Tracks[1].Head&sectorHeadMapMask == 0 {
+		t.Error("head map flag lost")
+	}
+	if got := file.Tracks[1].SectorHeadMap; !bytes.Equal(got, []byte{0, 0, 1, 0, 0, 0, 0, 0, 2}) {
+		t.Errorf("head map = %v", got)
+	}
+}
+
+func TestSectorHashes(t *testing.T) {
+	file, data := sssdImage(t)
+	track := &file.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(4)] = RecordUnavailable
+
+	hashes := file.SectorHashes()
+	if len(hashes) != 77 || len(hashes[[2]byte{1, 0}]) != 25 {
+		t.Fatalf("got %d tracks, %d sectors on track 1", len(hashes), len(hashes[[2]byte{1, 0}]))
+	}
+	if got, want := hashes[[2]byte{2, 0}][3], md5.Sum(data[(52+2)*128:(52+3)*128]); got != want {
+		t.Error("wrong digest for sector 2/0/3")
+	}
+	if _, ok := hashes[[2]byte{1, 0}][4]; ok {
+		t.Error("unavailable sector hashed")
+	}
+}
+
+func TestAllSectors(t *testing.T) {
+	file, data := sssdImage(t)
+	file.Tracks = file.Tracks[:2]
+	track := &file.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(4)] = RecordUnavailable
+
+	sectors := file.AllSectors()
+	if len(sectors) != 52 {
+		t.Fatalf("got %d sectors, want 52", len(sectors))
+	}
+	for i, s := range sectors {
+		if s.Cylinder != byte(i/26) || s.Head != 0 || s.Sector != byte(i%26+1) {
+			t.Fatalf("sector %d at %d/%d/%d", i, s.Cylinder, s.Head, s.Sector)
+		}
+		if i == 26+3 {
+			if s.Record != RecordUnavailable || s.Data != nil {
+				t.Errorf("unavailable sector = %+v", s)
+			}
+			continue
+		}
+		if !bytes.Equal(s.Data, data[i*128:(i+1)*128]) {
+			t.Errorf("sector %d has the wrong data", i)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package imd
+
+import "fmt"
+
+type format struct {
+	name string
+	Geometry
+}
+
+// formats lists the standard formats known to FormatName and GuessGeometry.
+// Raw image sizes must stay unique for GuessGeometry to be unambiguous.
+var formats = []format{
+	{"160K", Geometry{Cylinders: 40, Heads: 1, SectorsPerTrack: 8, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 48}},
+	{"180K", Geometry{Cylinders: 40, Heads: 1, SectorsPerTrack: 9, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 48}},
+	{"320K", Geometry{Cylinders: 40, Heads: 2, SectorsPerTrack: 8, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 48}},
+	{"360K", Geometry{Cylinders: 40, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 48}},
+	{"720K", Geometry{Cylinders: 80, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 96}},
+	{"800K", Geometry{Cylinders: 80, Heads: 2, SectorsPerTrack: 10, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 96}},
+	{"820K", Geometry{Cylinders: 82, Heads: 2, SectorsPerTrack: 10, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 96}},
+	{"1.2M", Geometry{Cylinders: 80, Heads: 2, SectorsPerTrack: 15, SectorSize: 512, ModeValue: 3, SectorBase: 1, TPI: 96}},
+	{"1.44M", Geometry{Cylinders: 80, Heads: 2, SectorsPerTrack: 18, SectorSize: 512, ModeValue: 3, SectorBase: 1, TPI: 96}},
+	{"1.68M", Geometry{Cylinders: 80, Heads: 2, SectorsPerTrack: 21, SectorSize: 512, ModeValue: 3, SectorBase: 1, TPI: 96, Interleave: 2}},
+	{"1.72M", Geometry{Cylinders: 82, Heads: 2, SectorsPerTrack: 21, SectorSize: 512, ModeValue: 3, SectorBase: 1, TPI: 96, Interleave: 2}},
+	{"8inch-SSSD", Geometry{Cylinders: 77, Heads: 1, SectorsPerTrack: 26, SectorSize: 128, ModeValue: 0, SectorBase: 1, TPI: 48}},
+}
+
+func (g Geometry) rawSize() int {
+	return g.Cylinders * g.Heads * g.SectorsPerTrack * g.SectorSize
+}
+
+// FormatName returns the name of the standard format matching f's geometry,
+// such as "1.44M" or "1.68M" for DMF, or "" if f matches none.
+func (f File) FormatName() string {
+	g := f.Geometry()
+	for _, format := range formats {
+		if g.Cylinders == format.Cylinders && g.Heads == format.Heads &&
+			g.SectorsPerTrack == format.SectorsPerTrack && g.SectorSize == format.SectorSize {
+			return format.name
+		}
+	}
+	return ""
+}
+
+// FormatGeometry returns the geometry of the standard format with the given
+// name, as returned by FormatName.
+func FormatGeometry(name string) (Geometry, bool) {
+	for _, format := range formats {
+		if format.name == name {
+			return format.Geometry, true
+		}
+	}
+	return Geometry{}, false
+}
+
+// MatchesFormat reports whether f is laid out as the named standard format,
+// see FormatName. If not, it describes each deviation: geometry fields that
+// differ, tracks that are missing, and tracks whose sector count, sector size
+// or mode differ from the format's.
+func (f File) MatchesFormat(name string) (bool, []string) {
+	want, ok := FormatGeometry(name)
+	if !ok {
+		return false, []string{fmt.Sprintf("unknown format %q", name)}
+	}
+
+	var deviations []string
+	g := f.Geometry()
+	for _, field := range []struct {
+		name      string
+		got, want int
+	}{
+		{"cylinders", g.Cylinders, want.Cylinders},
+		{"heads", g.Heads, want.Heads},
+		{"sectors per track", g.SectorsPerTrack, want.SectorsPerTrack},
+		{"sector size", g.SectorSize, want.SectorSize},
+		{"mode", int(g.ModeValue), int(want.ModeValue)},
+	} {
+		if field.got != field.want {
+			deviations = append(deviations, fmt.Sprintf("%s is %d, want %d", field.name, field.got, field.want))
+		}
+	}
+
+	tracks := f.TrackMap()
+	for c := 0; c < want.Cylinders; c++ {
+		for h := 0; h < want.Heads; h++ {
+			t := tracks[[2]byte{byte(c), byte(h)}]
+			switch {
+			case t == nil:
+				deviations = append(deviations, fmt.Sprintf("track %d/%d is missing", c, h))
+			case int(t.NumberOfSectors) != want.SectorsPerTrack || SectorSizeBytes(t.SectorSize) != want.SectorSize || t.ModeValue != want.ModeValue:
+				deviations = append(deviations, fmt.Sprintf("track %d/%d has %d sectors of %d bytes in mode %d",
+					c, h, t.NumberOfSectors, SectorSizeBytes(t.SectorSize), t.ModeValue))
+			}
+		}
+	}
+
+	return len(deviations) == 0, deviations
+}
+
+// GuessGeometry returns the geometry of the standard format whose raw image
+// is size bytes long.
+func GuessGeometry(size int) (Geometry, bool) {
+	for _, format := range formats {
+		if format.rawSize() == size {
+			return format.Geometry, true
+		}
+	}
+	return Geometry{}, false
+}
@@ -0,0 +1,162 @@
+package imd
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestFormats(t *testing.T) {
+	sizes := map[int]string{}
+	for _, format := range formats {
+		if other, ok := sizes[format.rawSize()]; ok {
+			t.Errorf("%s and %s have the same raw size", format.name, other)
+		}
+		sizes[format.rawSize()] = format.name
+
+		g, ok := GuessGeometry(format.rawSize())
+		if !ok || g != format.Geometry {
+			t.Errorf("GuessGeometry(%d) = %+v, %v, want %s", format.rawSize(), g, ok, format.name)
+			continue
+		}
+
+		file, err := FromRawImage(make([]byte, format.rawSize()), g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := file.FormatName(); got != format.name {
+			t.Errorf("FormatName() = %q, want %q", got, format.name)
+		}
+	}
+
+	if _, ok := GuessGeometry(1234); ok {
+		t.Error("GuessGeometry accepted an unknown size")
+	}
+}
+
+func TestFileGeometry(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Geometry{Cylinders: 40, Heads: 2, SectorsPerTrack: 10, SectorSize: 512, ModeValue: 5, SectorBase: 1, TPI: 48, Interleave: 4}
+	if got := file.Geometry(); got != want {
+		t.Errorf("Geometry() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFormatGeometry(t *testing.T) {
+	g, ok := FormatGeometry("720K")
+	if !ok || g.Cylinders != 80 || g.Heads != 2 || g.SectorsPerTrack != 9 {
+		t.Errorf("FormatGeometry(720K) = %+v, %v", g, ok)
+	}
+	if _, ok := FormatGeometry("nope"); ok {
+		t.Error("found an unknown format")
+	}
+}
+
+func TestAnomalousTracks(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TrackRef{{0, 0}, {0, 1}, {1, 0}, {1, 1}}
+	if got := file.AnomalousTracks(); !slices.Equal(got, want) {
+		t.Errorf("AnomalousTracks() = %v, want %v", got, want)
+	}
+}
+
+func TestLBA(t *testing.T) {
+	g, _ := FormatGeometry("360K")
+	file := NewBlankImage(g, 0xE5, "", "")
+
+	for _, test := range []struct {
+		lba               int
+		cyl, head, sector byte
+	}{
+		{0, 0, 0, 1},
+		{8, 0, 0, 9},
+		{9, 0, 1, 1},
+		{18, 1, 0, 1},
+		{719, 39, 1, 9},
+	} {
+		cyl, head, sector, err := file.LBA(test.lba, 512)
+		if err != nil || cyl != test.cyl || head != test.head || sector != test.sector {
+			t.Errorf("LBA(%d) = %d/%d/%d, %v, want %d/%d/%d", test.lba, cyl, head, sector, err, test.cyl, test.head, test.sector)
+		}
+	}
+
+	for _, lba := range []int{-1, 720} {
+		if _, _, _, err := file.LBA(lba, 512); err == nil {
+			t.Errorf("LBA(%d): no error", lba)
+		}
+	}
+	if _, _, _, err := file.LBA(0, 256); err == nil {
+		t.Error("wrong sector size accepted")
+	}
+}
+
+func TestMatchesFormat(t *testing.T) {
+	g, _ := FormatGeometry("360K")
+	file := NewBlankImage(g, 0xF6, "", "")
+	if ok, deviations := file.MatchesFormat("360K"); !ok || deviations != nil {
+		t.Errorf("MatchesFormat(360K) = %v, %q", ok, deviations)
+	}
+
+	file.Tracks = file.Tracks[:len(file.Tracks)-1]
+	file.Tracks[3].ModeValue = 2
+	ok, deviations := file.MatchesFormat("360K")
+	want := []string{"track 1/1 has 9 sectors of 512 bytes in mode 2", "track 39/1 is missing"}
+	if ok || !slices.Equal(deviations, want) {
+		t.Errorf("MatchesFormat(360K) = %v, %q, want %q", ok, deviations, want)
+	}
+
+	ok, deviations = file.MatchesFormat("720K")
+	if ok || len(deviations) < 2 || deviations[0] != "cylinders is 40, want 80" {
+		t.Errorf("MatchesFormat(720K) = %v, %q", ok, deviations)
+	}
+	if ok, _ := file.MatchesFormat("2.88M"); ok {
+		t.Error("matched an unknown format")
+	}
+}
+
+func TestSectorsPerTrack(t *testing.T) {
+	g, _ := FormatGeometry("720K")
+	file := NewBlankImage(g, 0xE5, "", "")
+	file.Tracks = append(file.Tracks, Track{Cylinder: 80, Unformatted: true})
+	if n, uniform := file.SectorsPerTrack(); n != 9 || !uniform {
+		t.Errorf("SectorsPerTrack() = %d, %v, want 9, true", n, uniform)
+	}
+
+	file.Tracks[0].FillMissingSectors([]byte{10})
+	if n, uniform := file.SectorsPerTrack(); n != 9 || uniform {
+		t.Errorf("SectorsPerTrack() = %d, %v, want 9, false", n, uniform)
+	}
+
+	if _, uniform := (File{}).SectorsPerTrack(); uniform {
+		t.Error("empty file is uniform")
+	}
+}
+
+func TestTrackCountByCylinder(t *testing.T) {
+	g, _ := FormatGeometry("360K")
+	file := NewBlankImage(g, 0xE5, "", "")
+	file.Tracks = slices.Delete(file.Tracks, 7, 8)
+	file.Tracks = append(file.Tracks, file.Tracks[0].clone())
+
+	counts := file.TrackCountByCylinder()
+	if len(counts) != 40 || counts[0] != 3 || counts[3] != 1 || counts[4] != 2 {
+		t.Errorf("counts = %v", counts)
+	}
+}
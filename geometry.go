@@ -0,0 +1,154 @@
+package imd
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Geometry describes a uniformly formatted disk.
+type Geometry struct {
+	Cylinders,
+	Heads,
+	SectorsPerTrack,
+	SectorSize int
+
+	ModeValue byte
+
+	// SectorBase is the logical number of the first sector on each track.
+	// Zero means 1, as on PC formats; set ZeroBased for systems that number
+	// sectors from 0.
+	SectorBase byte
+	ZeroBased  bool
+
+	// TPI is the track density the disk was formatted for, 48 or 96 tracks
+	// per inch, or 0 if unknown.
+	TPI int
+
+	// Interleave is the physical spacing between consecutive logical sectors.
+	// Zero and one both mean sectors are laid out in logical order.
+	Interleave int
+}
+
+// Geometry describes f by its highest cylinder and head and by the sector
+// count, sector size, mode and first sector number used by most formatted
+// tracks.
+// The interleave is taken from the first track. TPI is inferred from the
+// highest cylinder: 48 up to cylinder 42, which leaves room for the few extra
+// tracks of over-formatted 40-track disks, and 96 beyond. This assumes 5.25"
+// or 3.5" media; 8" disks are 48 TPI despite their 77 cylinders.
+func (f File) Geometry() Geometry {
+	var g Geometry
+	if len(f.Tracks) == 0 {
+		return g
+	}
+
+	sectors := map[byte]int{}
+	sizes := map[byte]int{}
+	modes := map[byte]int{}
+	bases := map[byte]int{}
+	for _, t := range f.Tracks {
+		g.Cylinders = max(g.Cylinders, int(t.Cylinder)+1)
+		g.Heads = max(g.Heads, int(t.headNumber())+1)
+		if t.Unformatted {
+			continue
+		}
+		sectors[t.NumberOfSectors]++
+		sizes[t.SectorSize]++
+		modes[t.ModeValue]++
+		if len(t.SectorNumberingMap) > 0 {
+			bases[slices.Min(t.SectorNumberingMap)]++
+		}
+	}
+
+	g.SectorsPerTrack = int(mostCommon(sectors))
+	g.SectorSize = SectorSizeBytes(mostCommon(sizes))
+	g.ModeValue = mostCommon(modes)
+	g.SectorBase = mostCommon(bases)
+	g.ZeroBased = g.SectorBase == 0 && bases[0] > 0
+	g.Interleave = f.Tracks[0].Interleave()
+	if g.Cylinders-1 <= 42 {
+		g.TPI = 48
+	} else {
+		g.TPI = 96
+	}
+	return g
+}
+
+// SectorsPerTrack returns the most common sector count of f's formatted
+// tracks, as Geometry does, and whether every formatted track has it. It
+// returns false for a File without formatted tracks.
+func (f File) SectorsPerTrack() (byte, bool) {
+	counts := map[byte]int{}
+	var formatted int
+	for _, t := range f.Tracks {
+		if !t.Unformatted {
+			counts[t.NumberOfSectors]++
+			formatted++
+		}
+	}
+	n := mostCommon(counts)
+	return n, formatted > 0 && counts[n] == formatted
+}
+
+// LBA maps the linear block address lba to the cylinder, head and logical
+// sector holding it, counting blocks through every head of a cylinder before
+// moving to the next cylinder, as RawImage lays them out. The mapping uses
+// f's Geometry, whose sector size must equal bytesPerSector.
+func (f File) LBA(lba int, bytesPerSector int) (cyl, head, sector byte, err error) {
+	g := f.Geometry()
+	if g.SectorSize != bytesPerSector {
+		return 0, 0, 0, fmt.Errorf("image has %d-byte sectors, not %d", g.SectorSize, bytesPerSector)
+	}
+	if lba < 0 || lba >= g.Cylinders*g.Heads*g.SectorsPerTrack {
+		return 0, 0, 0, fmt.Errorf("block %d is outside the disk", lba)
+	}
+
+	track := lba / g.SectorsPerTrack
+	return byte(track / g.Heads), byte(track % g.Heads), g.firstSector() + byte(lba%g.SectorsPerTrack), nil
+}
+
+// firstSector returns the number of the first sector on each track of g, see
+// SectorBase.
+func (g Geometry) firstSector() byte {
+	if g.SectorBase == 0 && !g.ZeroBased {
+		return 1
+	}
+	return g.SectorBase
+}
+
+// AnomalousTracks returns the tracks whose sector count, sector size or mode
+// differs from the most common one, see Geometry, in file order. These are
+// typically a boot track in a different format or a badly damaged track.
+func (f File) AnomalousTracks() []TrackRef {
+	g := f.Geometry()
+	var refs []TrackRef
+	for _, t := range f.Tracks {
+		if int(t.NumberOfSectors) != g.SectorsPerTrack || SectorSizeBytes(t.SectorSize) != g.SectorSize || t.ModeValue != g.ModeValue {
+			refs = append(refs, TrackRef{t.Cylinder, t.headNumber()})
+		}
+	}
+	return refs
+}
+
+// mostCommon returns the key with the highest count, preferring the lowest
+// key on ties so the result is deterministic.
+func mostCommon(counts map[byte]int) byte {
+	var best byte
+	for k, n := range counts {
+		if n > counts[best] || n == counts[best] && k < best {
+			best = k
+		}
+	}
+	return best
+}
+
+// TrackCountByCylinder returns the number of tracks f holds for each
+// cylinder, formatted or not. On a double-sided image a count of 1 reveals a
+// missing head and a count above 2 duplicate tracks.
+func (f File) TrackCountByCylinder() map[byte]int {
+	counts := make(map[byte]int)
+	for _, t := range f.Tracks {
+		counts[t.Cylinder]++
+	}
+	return counts
+}
@@ -1,3 +1,5 @@
 module imd
 
 go 1.23.0
+
+require github.com/klauspost/compress v1.18.0
@@ -0,0 +1,67 @@
+// Package imdtest builds damaged but well-formed IMD images for testing code
+// that has to cope with bad dumps.
+package imdtest
+
+import (
+	"fmt"
+	"time"
+
+	"imd"
+)
+
+// fixtureTime is the creation time recorded in the header of every image
+// built by StandardImage, so fixtures are reproducible.
+var fixtureTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// StandardImage returns a freshly formatted image of the named standard
+// format, such as "360K", "1.44M" or "8inch-SSSD", with every sector filled
+// with 0xE5.
+func StandardImage(format string) (imd.File, error) {
+	g, ok := imd.FormatGeometry(format)
+	if !ok {
+		return imd.File{}, fmt.Errorf("unknown format %q", format)
+	}
+	file := imd.NewBlankImage(g, 0xE5, "", "\r\n"+format+" test image\r\n")
+	return file, file.SetHeaderTime(fixtureTime)
+}
+
+// CorruptSector inverts every byte of the data of the given sector, leaving
+// its record type alone, as if the disk had silently returned bad data. It
+// panics if the sector does not exist or is unavailable.
+func CorruptSector(f *imd.File, cyl, head, sector byte) {
+	t, i := find(f, cyl, head, sector)
+	data := t.SectorDataRecords[i]
+	for j := range data {
+		data[j] = ^data[j]
+	}
+}
+
+// MarkBadCRC gives the given sector the error variant of its record type, as
+// if the disk had reported a data CRC error, keeping its data and its deleted
+// and compressed status. It panics if the sector does not exist or is
+// unavailable.
+func MarkBadCRC(f *imd.File, cyl, head, sector byte) {
+	t, i := find(f, cyl, head, sector)
+	switch record := t.SectorRecordTypes[i]; record {
+	case imd.RecordNormal, imd.RecordCompressed, imd.RecordDeleted, imd.RecordDeletedCompressed:
+		t.SectorRecordTypes[i] = record + imd.RecordError - imd.RecordNormal
+	}
+}
+
+// find returns the track holding the given sector and its physical index.
+func find(f *imd.File, cyl, head, sector byte) (*imd.Track, int) {
+	t := f.Track(cyl, head)
+	if t == nil {
+		panic(fmt.Sprintf("imdtest: no track %d/%d", cyl, head))
+	}
+	for i, n := range t.SectorNumberingMap {
+		if n != sector {
+			continue
+		}
+		if i >= len(t.SectorRecordTypes) || t.SectorRecordTypes[i] == imd.RecordUnavailable || i >= len(t.SectorDataRecords) {
+			break
+		}
+		return t, i
+	}
+	panic(fmt.Sprintf("imdtest: no sector %d on track %d/%d", sector, cyl, head))
+}
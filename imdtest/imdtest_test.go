@@ -0,0 +1,87 @@
+package imdtest
+
+import (
+	"bytes"
+	"testing"
+
+	"imd"
+)
+
+func newFile(t *testing.T) imd.File {
+	t.Helper()
+	data := make([]byte, 2*9*512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	file, err := imd.FromRawImage(data, imd.Geometry{Cylinders: 1, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, SectorBase: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Tracks[1].SectorRecordTypes[3] = imd.RecordDeletedCompressed
+	return file
+}
+
+func TestCorruptSector(t *testing.T) {
+	file := newFile(t)
+	original := bytes.Clone(file.Tracks[1].SectorDataRecords[2])
+
+	CorruptSector(&file, 0, 1, 3)
+	data := file.Tracks[1].SectorDataRecords[2]
+	for i := range data {
+		if data[i] != ^original[i] {
+			t.Fatalf("byte %d = %#x, want %#x", i, data[i], ^original[i])
+		}
+	}
+	if file.Tracks[1].SectorRecordTypes[2] != imd.RecordNormal {
+		t.Error("record type changed")
+	}
+}
+
+func TestMarkBadCRC(t *testing.T) {
+	file := newFile(t)
+
+	MarkBadCRC(&file, 0, 1, 1)
+	MarkBadCRC(&file, 0, 1, 4)
+	MarkBadCRC(&file, 0, 1, 4)
+	if got := file.Tracks[1].SectorRecordTypes[:4]; !bytes.Equal(got, []byte{imd.RecordError, imd.RecordNormal, imd.RecordNormal, imd.RecordDeletedErrorCompressed}) {
+		t.Errorf("record types = %v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := imd.Encode(&buf, file); err != nil {
+		t.Fatalf("damaged image does not encode: %v", err)
+	}
+}
+
+func TestMissingSectorPanics(t *testing.T) {
+	file := newFile(t)
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for a missing sector")
+		}
+	}()
+	MarkBadCRC(&file, 0, 0, 10)
+}
+
+func TestStandardImage(t *testing.T) {
+	for _, format := range []string{"1.44M", "360K", "720K", "1.2M", "8inch-SSSD"} {
+		file, err := StandardImage(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name := file.FormatName(); name != format {
+			t.Errorf("%s: FormatName = %q", format, name)
+		}
+		g := file.Geometry()
+		if stats := file.FillByteStats(); stats[0xE5] != g.Cylinders*g.Heads*g.SectorsPerTrack {
+			t.Errorf("%s: fill stats = %v", format, stats)
+		}
+		if err := file.VerifyRoundTrip(); err != nil {
+			t.Errorf("%s: %v", format, err)
+		}
+	}
+
+	if _, err := StandardImage("1.23M"); err == nil {
+		t.Error("no error for an unknown format")
+	}
+}
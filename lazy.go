@@ -0,0 +1,394 @@
+package imd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// LazyFile is an image whose sector data is only read from the underlying
+// io.ReaderAt when it is accessed.
+type LazyFile struct {
+	Header  Header
+	Comment string
+
+	Tracks []*LazyTrack
+
+	pos    int64
+	starts []int64
+}
+
+// LazyTrack is a track of a LazyFile. Its maps and record types are loaded up
+// front; sector data is read and cached by Sector. A LazyTrack is not safe
+// for concurrent use.
+type LazyTrack struct {
+	ModeValue,
+	Cylinder,
+	Head,
+	NumberOfSectors,
+	SectorSize byte
+
+	SectorNumberingMap,
+	SectorCylinderMap,
+	SectorHeadMap []byte
+
+	SectorRecordTypes []byte
+
+	// Unformatted is as for Track.
+	Unformatted bool
+
+	r       io.ReaderAt
+	offsets []int64
+	cache   [][]byte
+	order   []int
+}
+
+// OpenLazy indexes the image in the first size bytes of r without reading any
+// sector data. On ErrTruncatedComment the returned LazyFile holds the header
+// and the comment read so far.
+func OpenLazy(r io.ReaderAt, size int64) (*LazyFile, error) {
+	c := &cursor{r: io.NewSectionReader(r, 0, size), size: size}
+
+	header, err := c.read(0x1D)
+	if err != nil {
+		return nil, err
+	}
+	file := &LazyFile{Header: Header(string(header))}
+	if err := validateHeader(file.Header); err != nil {
+		return nil, err
+	}
+
+	file.Comment, err = c.readComment()
+	if err != nil {
+		file.layout()
+		return file, err
+	}
+
+	for c.off < size {
+		t, err := c.readTrack()
+		if err != nil {
+			return nil, err
+		}
+		file.Tracks = append(file.Tracks, t)
+	}
+
+	file.layout()
+	return file, nil
+}
+
+// Sector returns the data of the sector at physical index i, or nil if the
+// sector is unavailable.
+func (t *LazyTrack) Sector(i int) ([]byte, error) {
+	if i < 0 || i >= len(t.offsets) {
+		return nil, fmt.Errorf("sector index %d out of range", i)
+	}
+	if t.cache[i] != nil {
+		return t.cache[i], nil
+	}
+
+	data, err := t.read(i)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[i] = data
+	return data, nil
+}
+
+// read reads the data of the sector at physical index i from the underlying
+// io.ReaderAt without touching the cache.
+func (t *LazyTrack) read(i int) ([]byte, error) {
+	if t.SectorRecordTypes[i] == RecordUnavailable {
+		return nil, nil
+	}
+
+	data := make([]byte, SectorSizeBytes(t.SectorSize))
+	if isCompressed(t.SectorRecordTypes[i]) {
+		if err := readFullAt(t.r, data[:1], t.offsets[i]); err != nil {
+			return nil, err
+		}
+		fill(data, data[0])
+	} else if err := readFullAt(t.r, data, t.offsets[i]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Track reads every sector of t and returns it as a regular Track.
+func (t *LazyTrack) Track() (Track, error) {
+	records := make([][]byte, len(t.offsets))
+	for i := range records {
+		data, err := t.Sector(i)
+		if err != nil {
+			return Track{}, err
+		}
+		records[i] = data
+	}
+
+	return Track{
+		ModeValue:          t.ModeValue,
+		Cylinder:           t.Cylinder,
+		Head:               t.Head,
+		NumberOfSectors:    t.NumberOfSectors,
+		SectorSize:         t.SectorSize,
+		SectorNumberingMap: t.SectorNumberingMap,
+		SectorCylinderMap:  t.SectorCylinderMap,
+		SectorHeadMap:      t.SectorHeadMap,
+		SectorRecordTypes:  t.SectorRecordTypes,
+		SectorDataRecords:  records,
+		Unformatted:        t.Unformatted,
+	}, nil
+}
+
+// readFullAt fills p from r at off. A ReaderAt may report io.EOF along with
+// a read that ends exactly at the end of its input, which is not an error.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type cursor struct {
+	r    io.ReaderAt
+	off  int64
+	size int64
+}
+
+func (c *cursor) read(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if read, err := c.r.ReadAt(b, c.off); read < n {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	c.off += int64(n)
+	return b, nil
+}
+
+func (c *cursor) readComment() (string, error) {
+	var comment []byte
+	chunk := make([]byte, 512)
+	for {
+		n, err := c.r.ReadAt(chunk, c.off)
+		if i := bytes.IndexByte(chunk[:n], 0x1A); i >= 0 {
+			comment = append(comment, chunk[:i]...)
+			c.off += int64(i) + 1
+			return string(comment), nil
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = ErrTruncatedComment
+			}
+			return string(append(comment, chunk[:n]...)), err
+		}
+		comment = append(comment, chunk[:n]...)
+		c.off += int64(n)
+	}
+}
+
+func (c *cursor) readTrack() (*LazyTrack, error) {
+	h, err := c.read(5)
+	if err != nil {
+		return nil, err
+	}
+	t := &LazyTrack{
+		ModeValue:       h[0],
+		Cylinder:        h[1],
+		Head:            h[2],
+		NumberOfSectors: h[3],
+		SectorSize:      h[4],
+		r:               c.r,
+	}
+	n := int(t.NumberOfSectors)
+	if n == 0 || t.SectorSize == unformattedSizeCode {
+		t.Unformatted = true
+		n = 0
+	}
+	if !t.Unformatted && t.SectorSize > maxSectorSizeCode {
+		return nil, fmt.Errorf("track %d/%d: invalid size code %d", t.Cylinder, t.Head&headNumberMask, t.SectorSize)
+	}
+
+	if t.SectorNumberingMap, err = c.read(n); err != nil {
+		return nil, err
+	}
+	if t.Head&sectorCylinderMapMask != 0 {
+		if t.SectorCylinderMap, err = c.read(n); err != nil {
+			return nil, err
+		}
+	}
+	if t.Head&sectorHeadMapMask != 0 {
+		if t.SectorHeadMap, err = c.read(n); err != nil {
+			return nil, err
+		}
+	}
+
+	t.SectorRecordTypes = make([]byte, n)
+	t.offsets = make([]int64, n)
+	t.cache = make([][]byte, n)
+	for i := range n {
+		record, err := c.read(1)
+		if err != nil {
+			return nil, err
+		}
+		t.SectorRecordTypes[i] = record[0]
+		t.offsets[i] = c.off
+
+		switch {
+		case record[0] == RecordUnavailable:
+		case record[0] > RecordDeletedErrorCompressed:
+			return nil, fmt.Errorf("track %d/%d: sector %d has unknown record type %d",
+				t.Cylinder, t.Head&headNumberMask, t.SectorNumberingMap[i], record[0])
+		case isCompressed(record[0]):
+			c.off++
+		default:
+			c.off += int64(SectorSizeBytes(t.SectorSize))
+		}
+		if c.off > c.size {
+			return nil, errors.New("sector data runs past the end of the image")
+		}
+	}
+
+	return t, nil
+}
+
+// file reads every track of lf into a File.
+func (lf *LazyFile) file() (File, error) {
+	file := File{Header: lf.Header, Comment: lf.Comment}
+	for _, lt := range lf.Tracks {
+		t, err := lt.Track()
+		if err != nil {
+			return file, err
+		}
+		file.Tracks = append(file.Tracks, t)
+	}
+	return file, nil
+}
+
+// layout records the offset of each track within the raw image of lf, plus
+// the total size as a final entry. OpenLazy calls it once so that ReadAt
+// never writes to lf.
+func (lf *LazyFile) layout() {
+	lf.starts = make([]int64, len(lf.Tracks)+1)
+	for i, t := range lf.Tracks {
+		t.order = Track{SectorNumberingMap: t.SectorNumberingMap}.logicalOrder()
+		lf.starts[i+1] = lf.starts[i] + int64(len(t.order))*int64(SectorSizeBytes(t.SectorSize))
+	}
+}
+
+// Size returns the length of lf's raw image, laid out like File.RawImage with
+// unavailable sectors read as zeros. The raw image reflects the tracks as
+// OpenLazy indexed them; later changes to the tracks are not seen.
+func (lf *LazyFile) Size() int64 {
+	return lf.starts[len(lf.starts)-1]
+}
+
+// ReadAt reads from lf's raw image, see Size. It reads sector data straight
+// from the underlying io.ReaderAt, bypassing the cache used by
+// LazyTrack.Sector, so parallel calls are safe.
+func (lf *LazyFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	starts := lf.starts
+	var n int
+	for n < len(p) {
+		if off >= starts[len(starts)-1] {
+			return n, io.EOF
+		}
+		ti, _ := slices.BinarySearch(starts, off+1)
+		t := lf.Tracks[ti-1]
+
+		size := int64(SectorSizeBytes(t.SectorSize))
+		rel := off - starts[ti-1]
+		data, err := t.read(t.order[rel/size])
+		if err != nil {
+			return n, err
+		}
+
+		var copied int
+		if data == nil {
+			copied = int(min(int64(len(p)-n), size-rel%size))
+			clear(p[n : n+copied])
+		} else {
+			copied = copy(p[n:], data[rel%size:])
+		}
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// Read reads from lf's raw image at the current position, see Seek.
+func (lf *LazyFile) Read(p []byte) (int, error) {
+	n, err := lf.ReadAt(p, lf.pos)
+	lf.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the position in lf's raw image for the next Read.
+func (lf *LazyFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += lf.pos
+	case io.SeekEnd:
+		offset += lf.Size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	lf.pos = offset
+	return offset, nil
+}
+
+// TrackRef addresses a track by cylinder and head.
+type TrackRef struct {
+	Cylinder,
+	Head byte
+}
+
+// DecodeTracks decodes the tracks listed in want from the image in the first
+// size bytes of r, returning them in the same order. Only the sector data of
+// those tracks is read.
+func DecodeTracks(r io.ReaderAt, size int64, want []TrackRef) ([]Track, error) {
+	lf, err := OpenLazy(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]Track, len(want))
+	for i, ref := range want {
+		lt := lf.track(ref)
+		if lt == nil {
+			return nil, fmt.Errorf("no track %d/%d", ref.Cylinder, ref.Head)
+		}
+		if tracks[i], err = lt.Track(); err != nil {
+			return nil, err
+		}
+	}
+	return tracks, nil
+}
+
+// track returns the first track of lf at ref, or nil if there is none.
+func (lf *LazyFile) track(ref TrackRef) *LazyTrack {
+	for _, t := range lf.Tracks {
+		if t.Cylinder == ref.Cylinder && t.Head&headNumberMask == ref.Head {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package imd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestOpenLazy(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lazy, err := OpenLazy(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lazy.Comment != file.Comment {
+		t.Errorf("comment = %q, want %q", lazy.Comment, file.Comment)
+	}
+	if len(lazy.Tracks) != 80 {
+		t.Errorf("indexed %d tracks, want 80", len(lazy.Tracks))
+	}
+
+	for ti, want := range file.Tracks {
+		for i := range want.SectorDataRecords {
+			got, err := lazy.Tracks[ti].Sector(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want.SectorDataRecords[i]) {
+				t.Errorf("track %d sector %d differs from Decode", ti, i)
+			}
+		}
+	}
+
+	if _, err := OpenLazy(bytes.NewReader(data), int64(len(data)-1)); err == nil {
+		t.Error("OpenLazy accepted a truncated image")
+	}
+}
+
+func TestLazyFileReadSeeker(t *testing.T) {
+	file, data := sssdImage(t)
+	file.Tracks[1].SectorRecordTypes[3] = RecordUnavailable
+	file.Tracks[1].SectorDataRecords[3] = nil
+	clear(data[26*128+int(file.Tracks[1].SectorNumberingMap[3]-1)*128:][:128])
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := OpenLazy(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rs io.ReadSeeker = lazy
+	if lazy.Size() != int64(len(data)) {
+		t.Fatalf("Size() = %d, want %d", lazy.Size(), len(data))
+	}
+	all, err := io.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(all, data) {
+		t.Fatal("raw image read through LazyFile differs")
+	}
+
+	for _, off := range []int64{0, 127, 128, 26*128 - 5, 26*128 + 300, int64(len(data)) - 10} {
+		if _, err := rs.Seek(off, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		chunk := make([]byte, 200)
+		n, _ := io.ReadFull(rs, chunk)
+		if !bytes.Equal(chunk[:n], data[off:min(off+200, int64(len(data)))]) {
+			t.Errorf("read at %d differs", off)
+		}
+	}
+
+	if pos, _ := rs.Seek(-10, io.SeekEnd); pos != int64(len(data))-10 {
+		t.Errorf("Seek from end = %d", pos)
+	}
+	if _, err := rs.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek accepted a negative position")
+	}
+}
+
+func TestLazyFileParallelReadAt(t *testing.T) {
+	file, data := sssdImage(t)
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := OpenLazy(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			off := int64(i) * 1000
+			chunk := make([]byte, 3000)
+			n, err := lazy.ReadAt(chunk, off)
+			if err != nil && err != io.EOF {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(chunk[:n], data[off:off+int64(n)]) {
+				t.Errorf("parallel read at %d differs", off)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestDecodeTracks(t *testing.T) {
+	file, _ := sssdImage(t)
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReaderAt{r: bytes.NewReader(buf.Bytes())}
+	tracks, err := DecodeTracks(r, int64(buf.Len()), []TrackRef{{Cylinder: 40}, {Cylinder: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tracks) != 2 || !tracks[0].EqualContent(file.Tracks[40]) || !tracks[1].EqualContent(file.Tracks[2]) {
+		t.Error("DecodeTracks returned the wrong tracks")
+	}
+	if r.n >= int64(buf.Len()) {
+		t.Errorf("read %d bytes of a %d byte image", r.n, buf.Len())
+	}
+
+	if _, err := DecodeTracks(bytes.NewReader(buf.Bytes()), int64(buf.Len()), []TrackRef{{Cylinder: 1, Head: 1}}); err == nil {
+		t.Error("DecodeTracks found a missing track")
+	}
+}
+
+// hugeImage is an io.ReaderAt over a synthetic image of uncompressed tracks
+// of 255 sectors of 8192 bytes, each sector filled with its track's index.
+type hugeImage struct {
+	tracks int
+}
+
+const (
+	hugeHeader = "IMD 1.18: 17/10/2014 23:41:07\x1a"
+	hugeTrack  = 5 + 255 + 255*(1+8192)
+)
+
+func (h hugeImage) Size() int64 {
+	return int64(len(hugeHeader)) + int64(h.tracks)*hugeTrack
+}
+
+func (h hugeImage) ReadAt(p []byte, off int64) (int, error) {
+	for n := range p {
+		pos := off + int64(n)
+		if pos >= h.Size() {
+			return n, io.EOF
+		}
+		if pos < int64(len(hugeHeader)) {
+			p[n] = hugeHeader[pos]
+			continue
+		}
+		track, rel := (pos-int64(len(hugeHeader)))/hugeTrack, (pos-int64(len(hugeHeader)))%hugeTrack
+		switch {
+		case rel < 5:
+			p[n] = []byte{5, byte(track), 0, 255, 6}[rel]
+		case rel < 5+255:
+			p[n] = byte(rel - 5 + 1)
+		case (rel-5-255)%(1+8192) == 0:
+			p[n] = RecordNormal
+		default:
+			p[n] = byte(track)
+		}
+	}
+	return len(p), nil
+}
+
+func TestLazyLargeImage(t *testing.T) {
+	// Over 4 GiB of input and of raw image, so offsets need 64 bits.
+	image := hugeImage{tracks: 2100}
+	lazy, err := OpenLazy(image, image.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lazy.Tracks) != 2100 {
+		t.Fatalf("got %d tracks", len(lazy.Tracks))
+	}
+
+	last := lazy.Tracks[2099]
+	if off := last.offsets[254]; off != image.Size()-8192 {
+		t.Errorf("last sector at offset %d, want %d", off, image.Size()-8192)
+	}
+	data, err := last.Sector(254)
+	if err != nil || data[0] != 2099%256 {
+		t.Errorf("last sector holds %d, %v", data[0], err)
+	}
+
+	if size, want := lazy.Size(), int64(2100)*255*8192; size != want {
+		t.Errorf("raw size = %d, want %d", size, want)
+	}
+	off, err := lazy.Seek(-10, io.SeekEnd)
+	if err != nil || off < 1<<32 {
+		t.Fatalf("Seek = %d, %v", off, err)
+	}
+	buf := make([]byte, 20)
+	if n, err := lazy.Read(buf); n != 10 || err != nil || buf[9] != 2099%256 {
+		t.Errorf("Read = %d, %v, data %v", n, err, buf[:n])
+	}
+}
+
+// eofReaderAt reports io.EOF along with reads that end at the end of its
+// data, as io.ReaderAt allows.
+type eofReaderAt []byte
+
+func (r eofReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := bytes.NewReader(r).ReadAt(p, off)
+	if err == nil && off+int64(n) == int64(len(r)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestLazySectorAtEOF(t *testing.T) {
+	data := append([]byte("IMD 1.18: 17/10/2014 23:41:07\x1a"), 5, 0, 0, 2, 0, 1, 2, RecordCompressed, 0xE5, RecordNormal)
+	data = append(data, bytes.Repeat([]byte{1, 2}, 64)...)
+
+	lazy, err := OpenLazy(eofReaderAt(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sector, err := lazy.Tracks[0].Sector(1)
+	if err != nil || !bytes.Equal(sector, data[len(data)-128:]) {
+		t.Errorf("last sector = %v, %v", sector, err)
+	}
+}
@@ -0,0 +1,99 @@
+package imd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+)
+
+// Manifest is the JSON document written by WriteManifest. Fields are only
+// ever added to it, never renamed or removed.
+type Manifest struct {
+	// Version is the ImageDisk version from the header, e.g. "1.18".
+	Version string `json:"version"`
+	// Created is the header timestamp as "2006-01-02T15:04:05", in the
+	// unknown local time of the imaging machine, or empty if invalid.
+	Created string `json:"created,omitempty"`
+	Comment string `json:"comment"`
+
+	Geometry ManifestGeometry `json:"geometry"`
+	// Format is the standard format name, see File.FormatName, or empty.
+	Format string `json:"format,omitempty"`
+
+	// BadSectors lists every sector that is unavailable or has a data error,
+	// in track order and physical order within a track.
+	BadSectors []ManifestSector `json:"bad_sectors"`
+
+	// SHA256 is the hex SHA-256 of the image as written by Encode.
+	SHA256 string `json:"sha256"`
+}
+
+// ManifestGeometry is the geometry of a Manifest, see File.Geometry.
+type ManifestGeometry struct {
+	Cylinders       int  `json:"cylinders"`
+	Heads           int  `json:"heads"`
+	SectorsPerTrack int  `json:"sectors_per_track"`
+	SectorSize      int  `json:"sector_size"`
+	Mode            byte `json:"mode"`
+}
+
+// ManifestSector identifies a sector of a Manifest.
+type ManifestSector struct {
+	Cylinder byte `json:"cylinder"`
+	Head     byte `json:"head"`
+	Sector   byte `json:"sector"`
+	// Status is "unavailable" or "error".
+	Status string `json:"status"`
+}
+
+// WriteManifest writes a JSON Manifest describing f to w, for catalogs that
+// index images without parsing them. It fails if f cannot be encoded.
+func (f File) WriteManifest(w io.Writer) error {
+	h := sha256.New()
+	if err := Encode(h, f); err != nil {
+		return err
+	}
+
+	g := f.Geometry()
+	m := Manifest{
+		Comment: f.Comment,
+		Geometry: ManifestGeometry{
+			Cylinders:       g.Cylinders,
+			Heads:           g.Heads,
+			SectorsPerTrack: g.SectorsPerTrack,
+			SectorSize:      g.SectorSize,
+			Mode:            g.ModeValue,
+		},
+		Format:     f.FormatName(),
+		BadSectors: []ManifestSector{},
+		SHA256:     hex.EncodeToString(h.Sum(nil)),
+	}
+	if len(f.Header) >= 8 {
+		m.Version = f.Header.Version()
+	}
+	if len(f.Header) >= 10 {
+		if created, err := f.Header.Time(); err == nil {
+			m.Created = created.Format("2006-01-02T15:04:05")
+		}
+	}
+
+	for _, t := range f.Tracks {
+		for i, n := range t.SectorNumberingMap {
+			var status string
+			switch recordStatus(t.recordType(i)) {
+			case RecordUnavailable:
+				status = "unavailable"
+			case RecordError, RecordDeletedError:
+				status = "error"
+			default:
+				continue
+			}
+			m.BadSectors = append(m.BadSectors, ManifestSector{t.Cylinder, t.headNumber(), n, status})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
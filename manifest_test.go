@@ -0,0 +1,52 @@
+package imd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Comment = "\r\ntest\r\n"
+	file.Tracks[2].SectorRecordTypes[0] = RecordUnavailable
+	file.Tracks[3].SectorRecordTypes[1] = RecordDeletedError
+
+	var buf bytes.Buffer
+	if err := file.WriteManifest(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	var encoded bytes.Buffer
+	if err := Encode(&encoded, file); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(encoded.Bytes())
+
+	want := Manifest{
+		Version:  "1.18",
+		Created:  "2014-10-17T23:41:07",
+		Comment:  file.Comment,
+		Geometry: ManifestGeometry{Cylinders: 77, Heads: 1, SectorsPerTrack: 26, SectorSize: 128},
+		Format:   "8inch-SSSD",
+		BadSectors: []ManifestSector{
+			{2, 0, file.Tracks[2].SectorNumberingMap[0], "unavailable"},
+			{3, 0, file.Tracks[3].SectorNumberingMap[1], "error"},
+		},
+		SHA256: hex.EncodeToString(sum[:]),
+	}
+	got, _ := json.Marshal(m)
+	wanted, _ := json.Marshal(want)
+	if !bytes.Equal(got, wanted) {
+		t.Errorf("manifest = %s\nwant %s", got, wanted)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"bad_sectors"`)) {
+		t.Error("manifest lacks the bad_sectors key")
+	}
+}
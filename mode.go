@@ -0,0 +1,44 @@
+package imd
+
+import "fmt"
+
+// Mode is the data rate and encoding a track was recorded with.
+type Mode struct {
+	// Rate is the data rate in kbps.
+	Rate int
+	MFM  bool
+}
+
+var modes = [...]Mode{
+	{Rate: 500},
+	{Rate: 300},
+	{Rate: 250},
+	{Rate: 500, MFM: true},
+	{Rate: 300, MFM: true},
+	{Rate: 250, MFM: true},
+}
+
+func (m Mode) String() string {
+	if m.MFM {
+		return fmt.Sprintf("MFM %d kbps", m.Rate)
+	}
+	return fmt.Sprintf("FM %d kbps", m.Rate)
+}
+
+// Mode decodes t's mode value, reporting false for values other than 0-5.
+func (t Track) Mode() (Mode, bool) {
+	if int(t.ModeValue) >= len(modes) {
+		return Mode{}, false
+	}
+	return modes[t.ModeValue], true
+}
+
+// ModeString describes t's mode, such as "MFM 250 kbps", or returns
+// "unknown mode N" for an invalid mode value.
+func (t Track) ModeString() string {
+	m, ok := t.Mode()
+	if !ok {
+		return fmt.Sprintf("unknown mode %d", t.ModeValue)
+	}
+	return m.String()
+}
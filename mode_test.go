@@ -0,0 +1,18 @@
+package imd
+
+import "testing"
+
+func TestModeString(t *testing.T) {
+	tests := map[byte]string{
+		0: "FM 500 kbps",
+		2: "FM 250 kbps",
+		3: "MFM 500 kbps",
+		5: "MFM 250 kbps",
+		6: "unknown mode 6",
+	}
+	for value, want := range tests {
+		if got := (Track{ModeValue: value}).ModeString(); got != want {
+			t.Errorf("ModeString() for %d = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package imd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// PatchSector replaces the data of one sector in the image file at path. The
+// sector is recorded as normal data and the record types of its track are
+// recomputed; every other track is written back exactly as it was stored. The
+// image is written to a temporary file that is renamed over the original so
+// it is never left half written.
+func PatchSector(path string, cylinder, head, sector byte, data []byte) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	info, err := in.Stat()
+	if err != nil {
+		in.Close()
+		return err
+	}
+	lazy, err := OpenLazy(in, info.Size())
+	if err != nil {
+		in.Close()
+		return err
+	}
+	file, err := lazy.file()
+	in.Close()
+	if err != nil {
+		return err
+	}
+
+	t := file.Track(cylinder, head)
+	if t == nil {
+		return fmt.Errorf("no track %d/%d", cylinder, head)
+	}
+	i := t.sectorIndex(sector)
+	if i < 0 {
+		return fmt.Errorf("track %d/%d has no sector %d", cylinder, head, sector)
+	}
+	if size := SectorSizeBytes(t.SectorSize); len(data) != size {
+		return fmt.Errorf("sector data has %d bytes, want %d", len(data), size)
+	}
+
+	t.fillRecords()
+	t.SectorRecordTypes[i] = RecordNormal
+	t.SectorDataRecords[i] = data
+	copy(t.SectorRecordTypes, t.MinimalRecordTypes())
+
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if err := EncodeWithOptions(out, file, EncodeOptions{KeepRecordTypes: true}); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(out.Name(), path)
+}
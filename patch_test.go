@@ -0,0 +1,103 @@
+package imd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPatchSector(t *testing.T) {
+	original, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "disk.imd")
+	if err := os.WriteFile(path, original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PatchSector(path, 2, 1, 3, make([]byte, 100)); err == nil {
+		t.Fatal("PatchSector accepted data of the wrong size")
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, original) {
+		t.Fatal("failed PatchSector modified the file")
+	}
+
+	patch := bytes.Repeat([]byte("patched!"), 64)
+	if err := PatchSector(path, 2, 1, 3, patch); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, err := OpenLazy(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := lazy.file()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lazy, _ = OpenLazy(bytes.NewReader(original), int64(len(original)))
+	want, _ := lazy.file()
+
+	if len(patched.Tracks) != len(want.Tracks) {
+		t.Fatalf("patched image has %d tracks, want %d", len(patched.Tracks), len(want.Tracks))
+	}
+	for i := range want.Tracks {
+		track := patched.Tracks[i]
+		if track.Cylinder == 2 && track.headNumber() == 1 {
+			if !bytes.Equal(track.SectorDataRecords[track.sectorIndex(3)], patch) {
+				t.Error("sector was not patched")
+			}
+			continue
+		}
+		if !track.EqualContent(want.Tracks[i]) {
+			t.Errorf("track %d changed", i)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary file left behind")
+	}
+}
+
+func TestPatchSectorKeepsOtherTracks(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks[0].SectorDataRecords[0] = make([]byte, 128)
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, file, EncodeOptions{KeepRecordTypes: true}); err != nil {
+		t.Fatal(err)
+	}
+	original := buf.Bytes()
+	path := filepath.Join(t.TempDir(), "disk.imd")
+	if err := os.WriteFile(path, original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := PatchSector(path, 5, 0, 7, bytes.Repeat([]byte("0123456789abcdef"), 8)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(original) {
+		t.Fatalf("patched image has %d bytes, want %d", len(data), len(original))
+	}
+	first, last := -1, -1
+	for i := range data {
+		if data[i] != original[i] {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 || last-first >= 128 {
+		t.Errorf("bytes %d to %d changed, want only the patched sector", first, last)
+	}
+}
@@ -0,0 +1,48 @@
+package imd
+
+import "sync"
+
+// chunkSize is the size of the buffers a pooled decode carves sectors from.
+// Larger sectors are allocated on their own.
+const chunkSize = 64 << 10
+
+var chunks = sync.Pool{New: func() any {
+	chunk := make([]byte, chunkSize)
+	return &chunk
+}}
+
+// arena hands out sector buffers carved from pooled chunks. A nil arena
+// allocates every buffer separately.
+type arena struct {
+	chunks []*[]byte
+	free   []byte
+}
+
+func (a *arena) alloc(n int) []byte {
+	if a == nil || n > chunkSize {
+		return make([]byte, n)
+	}
+	if len(a.free) < n {
+		chunk := chunks.Get().(*[]byte)
+		a.chunks = append(a.chunks, chunk)
+		a.free = *chunk
+	}
+	b := a.free[:n:n]
+	a.free = a.free[n:]
+	return b
+}
+
+// Release returns the buffers of a File decoded with DecodeOptions.Pool for
+// reuse by later decodes and clears f.Tracks. The sector data of f, and of
+// any copy of f, must not be used afterwards. Release does nothing for other
+// Files.
+func (f *File) Release() {
+	if f.arena == nil {
+		return
+	}
+	for _, chunk := range f.arena.chunks {
+		chunks.Put(chunk)
+	}
+	f.arena = nil
+	f.Tracks = nil
+}
@@ -0,0 +1,62 @@
+package imd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestDecodePool(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{Pool: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got bytes.Buffer
+	plain.Dump(&want)
+	pooled.Dump(&got)
+	if want.String() != got.String() {
+		t.Error("pooled decode differs from a plain one")
+	}
+
+	pooled.Release()
+	if pooled.Tracks != nil {
+		t.Error("Release kept the tracks")
+	}
+	plain.Release()
+	if plain.Tracks == nil {
+		t.Error("Release cleared the tracks of an unpooled File")
+	}
+}
+
+func benchmarkDecode(b *testing.B, opts DecodeOptions) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for range b.N {
+		file, err := DecodeWithOptions(bytes.NewReader(data), opts)
+		if err != nil {
+			b.Fatal(err)
+		}
+		file.Release()
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	benchmarkDecode(b, DecodeOptions{})
+}
+
+func BenchmarkDecodePool(b *testing.B) {
+	benchmarkDecode(b, DecodeOptions{Pool: true})
+}
@@ -0,0 +1,74 @@
+package imd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Probe reports whether r starts with a valid IMD header. It consumes at most
+// the header bytes and never decodes any tracks. A stream too short to hold a
+// header is reported as not being an IMD file rather than as an error.
+func Probe(r io.Reader) (bool, error) {
+	var header [0x1D]byte
+	if _, err := io.ReadFull(r, header[:4]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+	if string(header[:4]) != "IMD " {
+		return false, nil
+	}
+
+	if _, err := io.ReadFull(r, header[4:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return validateHeader(Header(string(header[:]))) == nil, nil
+}
+
+// Version reads the header at the start of r and returns the version of
+// ImageDisk that wrote the image. It consumes only the header.
+func Version(r io.Reader) (major, minor int, err error) {
+	var header [0x1D]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	if err := validateHeader(Header(string(header[:]))); err != nil {
+		return 0, 0, err
+	}
+	version, _, _ := strings.Cut(string(header[4:]), ": ")
+	return parseVersion(version)
+}
+
+// DecodeSkipGarbage decodes an image that may be preceded by up to maxSkip
+// bytes of something else, such as the catalog header some archives wrap
+// images in. It decodes from the first valid header within that range.
+func DecodeSkipGarbage(r io.Reader, maxSkip int) (File, error) {
+	maxSkip = max(maxSkip, 0)
+	br := bufio.NewReaderSize(r, maxSkip+0x1D)
+	data, err := br.Peek(maxSkip + 0x1D)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return File{}, err
+	}
+
+	for skip := 0; skip <= maxSkip; skip++ {
+		i := bytes.Index(data[skip:], []byte("IMD "))
+		if i < 0 || skip+i > maxSkip || skip+i+0x1D > len(data) {
+			break
+		}
+		skip += i
+		if validateHeader(Header(string(data[skip:skip+0x1D]))) == nil {
+			br.Discard(skip)
+			return Decode(br)
+		}
+	}
+	return File{}, fmt.Errorf("no IMD header within the first %d bytes", maxSkip)
+}
@@ -0,0 +1,67 @@
+package imd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"IMD 1.18: 17/10/2014 23:41:07\r\n\x1a", true},
+		{"IMD 1.18: 17/10/2014 23:41:07", true},
+		{"IMD 1.18: 99/10/2014 23:41:07", false},
+		{"IMD", false},
+		{"IMD 1.18", false},
+		{"", false},
+		{"PK\x03\x04 not an image at all", false},
+	}
+
+	for _, test := range tests {
+		got, err := Probe(strings.NewReader(test.input))
+		if err != nil {
+			t.Errorf("Probe(%q): %v", test.input, err)
+		}
+		if got != test.want {
+			t.Errorf("Probe(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	major, minor, err := Version(strings.NewReader("IMD 1.18: 17/10/2014 23:41:07\r\ncomment\x1a"))
+	if err != nil || major != 1 || minor != 18 {
+		t.Errorf("Version = %d, %d, %v", major, minor, err)
+	}
+
+	for _, input := range []string{"IMD 1.x8: 17/10/2014 23:41:07", "IMD 1.18", "not an image at all, honestly"} {
+		if _, _, err := Version(strings.NewReader(input)); err == nil {
+			t.Errorf("Version(%q): no error", input)
+		}
+	}
+}
+
+func TestDecodeSkipGarbage(t *testing.T) {
+	image := string(commentImage("\r\nwrapped\r\n"))
+	wrapper := strings.Repeat("\x00", 100) + "IMD catalog entry" + strings.Repeat("\x00", 11)
+
+	file, err := DecodeSkipGarbage(strings.NewReader(wrapper+image), 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Comment != "\r\nwrapped\r\n" || len(file.Tracks) != 1 {
+		t.Errorf("decoded %q with %d tracks", file.Comment, len(file.Tracks))
+	}
+
+	if _, err := DecodeSkipGarbage(strings.NewReader(wrapper+image), 127); err == nil {
+		t.Error("header found beyond maxSkip")
+	}
+	if _, err := DecodeSkipGarbage(strings.NewReader(image), 0); err != nil {
+		t.Errorf("unwrapped image: %v", err)
+	}
+	if _, err := DecodeSkipGarbage(strings.NewReader("short IMD 1.18"), 128); err == nil {
+		t.Error("no error without a header")
+	}
+}
@@ -0,0 +1,65 @@
+package imd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ProtectionHints describes traits of f that commonly indicate copy
+// protection: sectors addressed as another cylinder or head, duplicate sector
+// numbers within a track, tracks with an unusual number of sectors for their
+// sector size, and sectors recorded with data errors. Such traits should be
+// preserved rather than repaired.
+func (f File) ProtectionHints() []string {
+	g := f.Geometry()
+	dominantSize, _ := SectorSizeCode(g.SectorSize)
+
+	var hints []string
+	for _, t := range f.Tracks {
+		prefix := fmt.Sprintf("track %d/%d: ", t.Cylinder, t.headNumber())
+
+		if foreign := t.SectorsWithForeignAddress(); len(foreign) > 0 {
+			numbers := make([]byte, len(foreign))
+			for i, j := range foreign {
+				numbers[i] = t.SectorNumberingMap[j]
+			}
+			hints = append(hints, prefix+"sectors "+joinNumbers(numbers)+" claim a foreign cylinder or head")
+		}
+
+		seen := map[byte]bool{}
+		var duplicates []byte
+		for _, n := range t.SectorNumberingMap {
+			if seen[n] && !slices.Contains(duplicates, n) {
+				duplicates = append(duplicates, n)
+			}
+			seen[n] = true
+		}
+		if len(duplicates) > 0 {
+			hints = append(hints, prefix+"duplicate sector numbers "+joinNumbers(duplicates))
+		}
+
+		if t.SectorSize == dominantSize && int(t.NumberOfSectors) != g.SectorsPerTrack {
+			hints = append(hints, fmt.Sprintf("%s%d sectors where most tracks have %d", prefix, t.NumberOfSectors, g.SectorsPerTrack))
+		}
+
+		var errored []byte
+		for i, n := range t.SectorNumberingMap {
+			if t.recordType(i) >= RecordError {
+				errored = append(errored, n)
+			}
+		}
+		if len(errored) > 0 {
+			hints = append(hints, prefix+"sectors "+joinNumbers(errored)+" recorded with data errors")
+		}
+	}
+	return hints
+}
+
+func joinNumbers(numbers []byte) string {
+	s := make([]string, len(numbers))
+	for i, n := range numbers {
+		s[i] = fmt.Sprint(n)
+	}
+	return strings.Join(s, ", ")
+}
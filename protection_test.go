@@ -0,0 +1,32 @@
+package imd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestProtectionHints(t *testing.T) {
+	file, _ := sssdImage(t)
+	if hints := file.ProtectionHints(); len(hints) != 0 {
+		t.Fatalf("clean image has hints %q", hints)
+	}
+
+	file.Tracks[5].SectorCylinderMap = make([]byte, 26)
+	for i := range file.Tracks[5].SectorCylinderMap {
+		file.Tracks[5].SectorCylinderMap[i] = 5
+	}
+	file.Tracks[5].SectorCylinderMap[0] = 40
+	file.Tracks[6].SectorNumberingMap[1] = file.Tracks[6].SectorNumberingMap[0]
+	file.Tracks[7].SectorRecordTypes[2] = RecordDeletedError
+	file.Tracks[8].appendSector(27, RecordUnavailable, nil)
+
+	want := []string{
+		"track 5/0: sectors 1 claim a foreign cylinder or head",
+		"track 6/0: duplicate sector numbers 1",
+		"track 7/0: sectors 10 recorded with data errors",
+		"track 8/0: 27 sectors where most tracks have 26",
+	}
+	if got := file.ProtectionHints(); !slices.Equal(got, want) {
+		t.Errorf("ProtectionHints() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,259 @@
+package imd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+type RawImageOptions struct {
+	// Fill is written in place of unavailable sectors.
+	Fill byte
+
+	// ErrorMap, when set, receives the status of every sector of the raw
+	// image, one byte per sector in image order: RecordUnavailable for a fill
+	// sector, RecordError or RecordDeletedError for data read with an error,
+	// and RecordNormal or RecordDeleted for good data.
+	ErrorMap *[]byte
+
+	// TreatErrorAsUnavailable fills sectors that were read with a data error,
+	// deleted or not, with Fill instead of their unreliable data, and reports
+	// them as RecordUnavailable in ErrorMap.
+	TreatErrorAsUnavailable bool
+
+	// Deskew also reorders the sectors of every track of f physically into
+	// logical order once the image is built, as Track.Deskew does, so that
+	// f is left with an interleave of one like a File re-imported from the
+	// image with FromRawImage. The raw image itself is the same either way.
+	Deskew bool
+}
+
+// RawImage returns the sector data of f as a flat image, track by track in
+// the order of f.Tracks and sector by sector in logical order.
+//
+// Sorting by logical number works whatever number a track's sectors start
+// from, so images of disks numbered from 0 export the same way as PC disks
+// numbered from 1.
+//
+// The output never depends on the physical layout of a track, so a skewed
+// track (see Track.Interleave) exports exactly like a deskewed one.
+// Re-importing the image with FromRawImage yields tracks with an interleave of
+// one unless the geometry asks otherwise; opts.Deskew applies the same
+// normalization to f directly. Deskewing is safe for anything that reads by
+// logical sector, such as filesystems, but it discards the rotational timing
+// that Interleave reports, so keep the original when the image is to be
+// written back to real media or studied for copy protection.
+func (f File) RawImage(opts RawImageOptions) ([]byte, error) {
+	var image, status []byte
+	for _, t := range f.Tracks {
+		size := SectorSizeBytes(t.SectorSize)
+		for _, i := range t.logicalOrder() {
+			bad := opts.TreatErrorAsUnavailable && t.recordType(i) >= RecordError
+			if i >= len(t.SectorDataRecords) || t.SectorDataRecords[i] == nil || bad {
+				status = append(status, RecordUnavailable)
+				image = append(image, make([]byte, size)...)
+				fill(image[len(image)-size:], opts.Fill)
+				continue
+			}
+			if len(t.SectorDataRecords[i]) != size {
+				return nil, fmt.Errorf("track %d/%d: sector %d has %d bytes, want %d",
+					t.Cylinder, t.headNumber(), t.SectorNumberingMap[i], len(t.SectorDataRecords[i]), size)
+			}
+			image = append(image, t.SectorDataRecords[i]...)
+			status = append(status, recordStatus(t.recordType(i)))
+		}
+	}
+
+	if opts.ErrorMap != nil {
+		*opts.ErrorMap = status
+	}
+	if opts.Deskew {
+		f.Deskew()
+	}
+	return image, nil
+}
+
+// FromRawImage builds a File from a flat sector image laid out as described
+// by g. Sectors are numbered from 1 unless g.SectorBase or g.ZeroBased says
+// otherwise, and placed according to g.Interleave. The returned File has no
+// header.
+func FromRawImage(data []byte, g Geometry) (File, error) {
+	var file File
+
+	sizeCode, err := SectorSizeCode(g.SectorSize)
+	if err != nil {
+		return file, err
+	}
+	if g.Cylinders <= 0 || g.Cylinders > 256 || g.Heads <= 0 || g.Heads > 2 || g.SectorsPerTrack <= 0 || g.SectorsPerTrack > 255 {
+		return file, errors.New("invalid geometry")
+	}
+	if int(g.firstSector())+g.SectorsPerTrack > 256 {
+		return file, errors.New("sector numbers overflow past 255")
+	}
+	if len(data) != g.Cylinders*g.Heads*g.SectorsPerTrack*g.SectorSize {
+		return file, errors.New("image size does not match geometry")
+	}
+
+	order := interleaveOrder(g.SectorsPerTrack, g.Interleave)
+	for c := 0; c < g.Cylinders; c++ {
+		for h := 0; h < g.Heads; h++ {
+			t := Track{
+				ModeValue:          g.ModeValue,
+				Cylinder:           byte(c),
+				Head:               byte(h),
+				NumberOfSectors:    byte(g.SectorsPerTrack),
+				SectorSize:         sizeCode,
+				SectorNumberingMap: make([]byte, g.SectorsPerTrack),
+				SectorRecordTypes:  make([]byte, g.SectorsPerTrack),
+				SectorDataRecords:  make([][]byte, g.SectorsPerTrack),
+			}
+			for i, logical := range order {
+				t.SectorNumberingMap[i] = g.firstSector() + byte(logical)
+				t.SectorRecordTypes[i] = RecordNormal
+				t.SectorDataRecords[i] = slices.Clone(data[logical*g.SectorSize : (logical+1)*g.SectorSize])
+			}
+			data = data[g.SectorsPerTrack*g.SectorSize:]
+
+			file.Tracks = append(file.Tracks, t)
+		}
+	}
+
+	return file, nil
+}
+
+// NewBlankImage returns a File laid out as described by geom with every
+// sector present, filled with fill and recorded as compressed, ready to have
+// a filesystem written into it. If geom is invalid the File has no tracks.
+func NewBlankImage(geom Geometry, fill byte, h Header, comment string) File {
+	file, _ := FromRawImage(bytes.Repeat([]byte{fill}, max(geom.rawSize(), 0)), geom)
+	for _, t := range file.Tracks {
+		for i := range t.SectorRecordTypes {
+			t.SectorRecordTypes[i] = RecordCompressed
+		}
+	}
+
+	file.Header = h
+	file.Comment = comment
+	return file
+}
+
+// interleaveOrder returns which logical sector (counting from 0) sits at each
+// physical position of a track with n sectors.
+func interleaveOrder(n, interleave int) []int {
+	order := make([]int, n)
+	if interleave <= 1 {
+		for i := range order {
+			order[i] = i
+		}
+		return order
+	}
+
+	used := make([]bool, n)
+	pos := 0
+	for logical := 0; logical < n; logical++ {
+		for used[pos] {
+			pos = (pos + 1) % n
+		}
+		order[pos] = logical
+		used[pos] = true
+		pos = (pos + interleave) % n
+	}
+	return order
+}
+
+// logicalOrder returns the physical indices of t's sectors sorted by their
+// logical sector number.
+func (t Track) logicalOrder() []int {
+	order := make([]int, len(t.SectorNumberingMap))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return int(t.SectorNumberingMap[a]) - int(t.SectorNumberingMap[b])
+	})
+	return order
+}
+
+// Interleave returns the physical distance between the two lowest-numbered
+// logical sectors of t, or 1 when there are fewer than two sectors.
+// Interleave is a property of the physical layout only; it does not affect
+// RawImage output.
+func (t Track) Interleave() int {
+	n := len(t.SectorNumberingMap)
+	if n < 2 {
+		return 1
+	}
+	order := t.logicalOrder()
+	return (order[1] - order[0] + n) % n
+}
+
+// Deskew reorders the sectors of t physically so that they are stored in
+// logical order, giving an interleave of one. Sector contents and their
+// logical numbers are unchanged, so anything reading by logical sector
+// (RawImage, filesystems) sees the same data. The original rotational timing
+// of the disk is lost, which matters when the image is written back to real
+// media or used to study copy protection.
+func (t *Track) Deskew() {
+	order := t.logicalOrder()
+	t.SectorNumberingMap = permute(t.SectorNumberingMap, order)
+	t.SectorCylinderMap = permute(t.SectorCylinderMap, order)
+	t.SectorHeadMap = permute(t.SectorHeadMap, order)
+	t.SectorRecordTypes = permute(t.SectorRecordTypes, order)
+	t.SectorDataRecords = permute(t.SectorDataRecords, order)
+}
+
+// Deskew deskews every track of f, see Track.Deskew.
+func (f *File) Deskew() {
+	for i := range f.Tracks {
+		f.Tracks[i].Deskew()
+	}
+}
+
+func permute[T any](s []T, order []int) []T {
+	if len(s) != len(order) {
+		return s
+	}
+	out := make([]T, len(s))
+	for i, j := range order {
+		out[i] = s[j]
+	}
+	return out
+}
+
+// RangeReader returns a reader over the raw image of the tracks from
+// startCyl/startHead through endCyl/endHead inclusive, in cylinder and head
+// order, as RawImage would produce it. Every track in the range must exist
+// and all of them must use the same sector size.
+func (f File) RangeReader(startCyl, startHead, endCyl, endHead byte) (io.Reader, error) {
+	if startCyl > endCyl || startCyl == endCyl && startHead > endHead {
+		return nil, errors.New("range ends before it starts")
+	}
+
+	heads := byte(f.Geometry().Heads)
+	var tracks []Track
+	for c, h := startCyl, startHead; ; {
+		t := f.Track(c, h)
+		if t == nil {
+			return nil, fmt.Errorf("no track %d/%d", c, h)
+		}
+		if len(tracks) > 0 && t.SectorSize != tracks[0].SectorSize {
+			return nil, fmt.Errorf("track %d/%d has a different sector size", c, h)
+		}
+		tracks = append(tracks, *t)
+
+		if c == endCyl && h == endHead {
+			break
+		}
+		if h++; h >= heads {
+			c, h = c+1, 0
+		}
+	}
+
+	image, err := File{Tracks: tracks}.RawImage(RawImageOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(image), nil
+}
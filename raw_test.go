@@ -0,0 +1,193 @@
+package imd
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestRawImageRoundTrip(t *testing.T) {
+	g := Geometry{Cylinders: 2, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, ModeValue: 5, Interleave: 3}
+	data := make([]byte, 2*2*9*512)
+	for i := range data {
+		data[i] = byte(i / 512)
+	}
+
+	file, err := FromRawImage(data, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := file.Tracks[0].Interleave(); got != 3 {
+		t.Errorf("Interleave() = %d, want 3", got)
+	}
+
+	raw, err := file.RawImage(RawImageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, data) {
+		t.Fatal("raw image does not match the imported data")
+	}
+
+	raw, err = file.RawImage(RawImageOptions{Deskew: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, data) {
+		t.Fatal("deskewed export differs")
+	}
+	if got := file.Tracks[0].Interleave(); got != 1 {
+		t.Errorf("Interleave() after Deskew = %d, want 1", got)
+	}
+	if want := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}; !slices.Equal(file.Tracks[1].SectorNumberingMap, want) {
+		t.Errorf("numbering map after Deskew = %v, want %v", file.Tracks[1].SectorNumberingMap, want)
+	}
+	raw, _ = file.RawImage(RawImageOptions{})
+	if !bytes.Equal(raw, data) {
+		t.Fatal("Deskew changed the logical contents")
+	}
+}
+
+func TestRawImageFill(t *testing.T) {
+	file := File{Tracks: []Track{{
+		NumberOfSectors:    2,
+		SectorNumberingMap: []byte{2, 1},
+		SectorRecordTypes:  []byte{RecordNormal, RecordUnavailable},
+		SectorDataRecords:  [][]byte{bytes.Repeat([]byte{1}, 128), nil},
+	}}}
+
+	raw, err := file.RawImage(RawImageOptions{Fill: 0xE5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(bytes.Repeat([]byte{0xE5}, 128), bytes.Repeat([]byte{1}, 128)...); !bytes.Equal(raw, want) {
+		t.Fatal("unavailable sector was not filled")
+	}
+}
+
+func TestRawImageErrorMap(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks = file.Tracks[:2]
+	track := &file.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(1)] = RecordErrorCompressed
+	track.SectorRecordTypes[track.sectorIndex(2)] = RecordUnavailable
+	track.SectorRecordTypes[track.sectorIndex(3)] = RecordDeleted
+
+	var errorMap []byte
+	if _, err := file.RawImage(RawImageOptions{ErrorMap: &errorMap}); err != nil {
+		t.Fatal(err)
+	}
+	if len(errorMap) != 52 {
+		t.Fatalf("error map has %d entries, want 52", len(errorMap))
+	}
+	want := bytes.Repeat([]byte{RecordNormal}, 52)
+	want[26], want[27], want[28] = RecordError, RecordUnavailable, RecordDeleted
+	if !bytes.Equal(errorMap, want) {
+		t.Errorf("error map = %v", errorMap)
+	}
+
+	raw, err := file.RawImage(RawImageOptions{Fill: 0xF6, ErrorMap: &errorMap, TreatErrorAsUnavailable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[26] = RecordUnavailable
+	if !bytes.Equal(errorMap, want) {
+		t.Errorf("error map with errors unavailable = %v", errorMap)
+	}
+	if !bytes.Equal(raw[26*128:27*128], bytes.Repeat([]byte{0xF6}, 128)) {
+		t.Error("error sector not filled")
+	}
+}
+
+func TestFromRawImageSectorBase(t *testing.T) {
+	data := make([]byte, 16*256)
+	for i := range data {
+		data[i] = byte(i / 256)
+	}
+
+	pc, err := FromRawImage(data, Geometry{Cylinders: 1, Heads: 1, SectorsPerTrack: 16, SectorSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Min(pc.Tracks[0].SectorNumberingMap); got != 1 {
+		t.Errorf("lowest sector number by default = %d, want 1", got)
+	}
+
+	file, err := FromRawImage(data, Geometry{Cylinders: 1, Heads: 1, SectorsPerTrack: 16, SectorSize: 256, ZeroBased: true, Interleave: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Min(file.Tracks[0].SectorNumberingMap); got != 0 {
+		t.Errorf("lowest sector number = %d, want 0", got)
+	}
+	if g := file.Geometry(); !g.ZeroBased || g.SectorBase != 0 {
+		t.Errorf("Geometry() = base %d, zero-based %v", g.SectorBase, g.ZeroBased)
+	}
+	raw, _ := file.RawImage(RawImageOptions{})
+	if !bytes.Equal(raw, data) {
+		t.Error("base-0 image did not round trip")
+	}
+
+	if _, err := FromRawImage(data, Geometry{Cylinders: 1, Heads: 1, SectorsPerTrack: 16, SectorSize: 256, SectorBase: 250}); err == nil {
+		t.Error("FromRawImage accepted sector numbers past 255")
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	g := Geometry{Cylinders: 4, Heads: 2, SectorsPerTrack: 9, SectorSize: 512, SectorBase: 1}
+	data := make([]byte, 4*2*9*512)
+	for i := range data {
+		data[i] = byte(i / 512)
+	}
+	file, err := FromRawImage(data, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := file.RangeReader(1, 1, 3, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(r)
+	const track = 9 * 512
+	if !bytes.Equal(got, data[3*track:7*track]) {
+		t.Error("RangeReader returned the wrong tracks")
+	}
+
+	if _, err := file.RangeReader(2, 0, 1, 1); err == nil {
+		t.Error("RangeReader accepted a reversed range")
+	}
+	if _, err := file.RangeReader(3, 0, 4, 0); err == nil {
+		t.Error("RangeReader accepted a missing track")
+	}
+	file.Tracks[4].SectorSize = 1
+	if _, err := file.RangeReader(1, 0, 2, 1); err == nil {
+		t.Error("RangeReader accepted mixed sector sizes")
+	}
+}
+
+func TestNewBlankImage(t *testing.T) {
+	g, _ := GuessGeometry(1474560)
+	file := NewBlankImage(g, 0xF6, Header("IMD 1.18: 17/10/2014 23:41:07"), "blank")
+	if file.FormatName() != "1.44M" {
+		t.Errorf("FormatName() = %q, want 1.44M", file.FormatName())
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	if want := 29 + 5 + 1 + 160*(5+18+18*2); buf.Len() != want {
+		t.Errorf("encoded %d bytes, want %d", buf.Len(), want)
+	}
+
+	raw, _ := file.RawImage(RawImageOptions{})
+	if !bytes.Equal(raw, bytes.Repeat([]byte{0xF6}, 1474560)) {
+		t.Error("blank image is not filled")
+	}
+
+	if bad := NewBlankImage(Geometry{}, 0, "", ""); len(bad.Tracks) != 0 {
+		t.Error("NewBlankImage built tracks for an invalid geometry")
+	}
+}
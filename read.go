@@ -1,7 +1,9 @@
 package imd
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 	"strings"
@@ -19,6 +21,35 @@ func (h Header) Time() (time.Time, error) {
 	return time.Parse("02/01/2006 15:04:05", string(h[10:]))
 }
 
+// Valid reports whether h is a well-formed header, such as
+// "IMD 1.18: 17/10/2014 23:41:07". Version and Time may only be used on valid
+// headers.
+func (h Header) Valid() bool {
+	return validateHeader(h) == nil
+}
+
+// Raw returns h as it appears in the image.
+func (h Header) Raw() string {
+	return string(h)
+}
+
+// SetHeaderTime replaces the timestamp of f's header with t, keeping the
+// version of a valid header and writing version 1.18 otherwise. Pinning the
+// time makes the output of Encode reproducible. It fails if t cannot be
+// written in the header's format, such as a year beyond 9999.
+func (f *File) SetHeaderTime(t time.Time) error {
+	version := "1.18"
+	if f.Header.Valid() {
+		version, _, _ = strings.Cut(string(f.Header[4:]), ": ")
+	}
+	h := Header("IMD " + version + ": " + t.Format("02/01/2006 15:04:05"))
+	if err := validateHeader(h); err != nil {
+		return err
+	}
+	f.Header = h
+	return nil
+}
+
 type Track struct {
 	ModeValue,
 	Cylinder,
@@ -30,115 +61,249 @@ type Track struct {
 	SectorCylinderMap,
 	SectorHeadMap []byte
 
+	// SectorRecordTypes holds the record type of each sector as read,
+	// including whether it was stored compressed; SectorDataRecords always
+	// holds the expanded data.
+	SectorRecordTypes []byte
 	SectorDataRecords [][]byte
+
+	// Unformatted marks a track recorded without sectors, either with a
+	// sector count of zero or with the size code 0xFF some imagers use as a
+	// sentinel. Such a track has no maps or data.
+	Unformatted bool
 }
 
+// unformattedSizeCode is the sector size code marking an unformatted track.
+const unformattedSizeCode = 0xFF
+
+const (
+	RecordUnavailable = iota
+	RecordNormal
+	RecordCompressed
+	RecordDeleted
+	RecordDeletedCompressed
+	RecordError
+	RecordErrorCompressed
+	RecordDeletedError
+	RecordDeletedErrorCompressed
+)
+
 type File struct {
 	Header  Header
 	Comment string
 
 	Tracks []Track
+
+	offsets []TrackOffsets
+	arena   *arena
 }
 
-func Decode(r io.Reader) (file File, err error) {
-	var header [0x1D]byte
-	if _, err := r.Read(header[:]); err != nil {
-		return file, err
+// TrackOffsets holds the input byte offsets of a decoded track.
+type TrackOffsets struct {
+	// Track is the offset of the track's mode byte.
+	Track int64
+	// Sectors holds the offset of each sector's record type byte, in
+	// physical order.
+	Sectors []int64
+}
+
+// Offsets returns the input offsets of f's tracks, parallel to f.Tracks, if f
+// was decoded with DecodeOptions.RecordOffsets. Offsets are not updated when
+// f is modified.
+func (f File) Offsets() []TrackOffsets {
+	return f.offsets
+}
+
+// DecodeMetrics describes the work of a decode, see DecodeOptions.Metrics.
+type DecodeMetrics struct {
+	// Tracks holds one entry per decoded track, in input order.
+	Tracks []TrackMetrics
+}
+
+// TrackMetrics describes the decoding of one track.
+type TrackMetrics struct {
+	Cylinder, Head byte
+	// Bytes is the length of the track in the input, header included.
+	Bytes int64
+	// CompressedSectors and UncompressedSectors count the sectors stored as
+	// a single fill byte and those stored in full.
+	CompressedSectors, UncompressedSectors int
+	// Duration is the time spent decoding the track, reads included.
+	Duration time.Duration
+}
+
+// ErrTruncatedComment is returned when the image ends before the comment
+// terminator. The returned File still holds the header and the comment read
+// so far.
+var ErrTruncatedComment = errors.New("image ends inside the comment")
+
+// ErrTrailingData is returned with DecodeOptions.StrictEOF when input remains
+// after the last track. The returned File holds everything decoded.
+var ErrTrailingData = errors.New("unexpected data after the last track")
+
+type DecodeOptions struct {
+	// Warnings, when set, collects a description of every anomaly the
+	// decoder tolerated instead of failing.
+	Warnings *[]string
+
+	// Recover makes Decode stop at an unknown record type and return the
+	// tracks read so far instead of failing. Nothing after that record can be
+	// decoded since its length is unknown.
+	Recover bool
+
+	// RecordOffsets makes Decode remember where each track and sector starts
+	// in the input, see File.Offsets.
+	RecordOffsets bool
+
+	// SectorSizeOverride maps size codes to sector lengths in bytes, taking
+	// precedence over the standard 128 << code. It is meant for recovering
+	// images that misuse size codes; Encode and RawImage only know the
+	// standard sizes and reject sectors decoded with an overridden length.
+	SectorSizeOverride map[byte]int
+
+	// StrictEOF makes Decode fail with ErrTrailingData if any input remains
+	// after the last track, such as another image that follows it or the
+	// data after the record at which Recover stopped.
+	StrictEOF bool
+
+	// Pool makes Decode carve sector data out of large pooled buffers
+	// instead of allocating every sector, which relieves the garbage
+	// collector when scanning many images. Call File.Release once the File
+	// is no longer needed to make the buffers available again.
+	Pool bool
+
+	// UnknownRecordHandler, when set, is called for a sector with a record
+	// type above RecordDeletedErrorCompressed instead of failing or
+	// recovering. It must consume exactly the data of that record from r and
+	// return the sector's contents, which Decode stores along with the
+	// record type as read. An error from the handler fails the decode.
+	UnknownRecordHandler func(record byte, r io.Reader) ([]byte, error)
+
+	// Metrics, when set, is reset and filled with per-track statistics for
+	// profiling. Leaving it nil costs nothing.
+	Metrics *DecodeMetrics
+}
+
+func (opts DecodeOptions) sectorSizeBytes(code byte) int {
+	if size, ok := opts.SectorSizeOverride[code]; ok {
+		return size
 	}
-	file.Header = Header(string(header[:]))
-	if err := validateHeader(file.Header); err != nil {
-		return file, err
+	return SectorSizeBytes(code)
+}
+
+func (opts DecodeOptions) warn(format string, args ...any) {
+	if opts.Warnings != nil {
+		*opts.Warnings = append(*opts.Warnings, fmt.Sprintf(format, args...))
 	}
+}
+
+func Decode(r io.Reader) (File, error) {
+	return DecodeWithOptions(r, DecodeOptions{})
+}
 
-	file.Comment, err = readStringASCIIEOF(r)
+func DecodeWithOptions(r io.Reader, opts DecodeOptions) (File, error) {
+	d, err := newDecoder(r, opts)
+	file := File{Header: d.header, Comment: d.comment, arena: d.arena}
 	if err != nil {
-		return
+		return file, err
 	}
 
 	for {
-		modeValue, err := readByte(r)
-		if err != nil {
-			break
-		}
-		cylinder, err := readByte(r)
-		if err != nil {
-			return file, err
-		}
-		head, err := readByte(r)
-		if err != nil {
-			return file, err
-		}
-		numberOfSectors, err := readByte(r)
+		t, err := d.NextTrack()
 		if err != nil {
+			file.offsets = d.offsets
+			if err == io.EOF {
+				err = nil
+			}
 			return file, err
 		}
-		sectorSize, err := readByte(r)
+		file.Tracks = append(file.Tracks, t)
+	}
+}
+
+// DecodeBytes decodes the image at the start of data and returns the number
+// of bytes it consumed. Decoding stops at the end of data or at the magic of
+// another image.
+func DecodeBytes(data []byte) (File, int, error) {
+	r := &sliceReader{data: data}
+	file, err := Decode(r)
+	return file, r.off, err
+}
+
+// DecodeAll decodes every image in a stream of concatenated IMD files.
+func DecodeAll(r io.Reader) ([]File, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []File
+	for len(data) > 0 {
+		file, n, err := DecodeBytes(data)
 		if err != nil {
-			return file, err
+			return files, err
 		}
+		files = append(files, file)
 
-		sectorNumberingMap := make([]byte, numberOfSectors)
-		if _, err := r.Read(sectorNumberingMap); err != nil {
-			return file, err
+		data = data[n:]
+		if len(data) > 0 && !bytes.HasPrefix(data, []byte("IMD ")) {
+			return files, fmt.Errorf("unexpected data after image %d", len(files))
 		}
+	}
 
-		var sectorCylinderMap, sectorHeadMap []byte
+	return files, nil
+}
 
-		if head&sectorCylinderMapMask != 0 {
-			sectorCylinderMap = make([]byte, numberOfSectors)
-			if _, err := r.Read(sectorCylinderMap); err != nil {
-				return file, err
-			}
-		}
+// nextIsHeader reports whether r can peek ahead and is positioned at the
+// magic of another image. Mode values never collide with the 'I' of the magic.
+func nextIsHeader(r io.Reader) bool {
+	p, ok := r.(interface{ Peek(int) ([]byte, error) })
+	if !ok {
+		return false
+	}
+	b, _ := p.Peek(4)
+	return string(b) == "IMD "
+}
 
-		if head&sectorHeadMapMask != 0 {
-			sectorHeadMap = make([]byte, numberOfSectors)
-			if _, err := r.Read(sectorHeadMap); err != nil {
-				return file, err
-			}
-		}
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-		var sectorDataRecords = make([][]byte, numberOfSectors)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
 
-		var record byte
-		for i := byte(0); i < numberOfSectors; i++ {
-			if err := readBytePtr(r, &record); err != nil {
-				return file, err
-			}
+func (c *countingReader) Peek(n int) ([]byte, error) {
+	p, ok := c.r.(interface{ Peek(int) ([]byte, error) })
+	if !ok {
+		return nil, errors.New("underlying reader cannot peek")
+	}
+	return p.Peek(n)
+}
 
-			switch record {
-			case 0: // unavailable
-				continue
-			case 1, 3, 5, 7: // regular sector data
-				sectorDataRecords[sectorNumberingMap[i]] = make([]byte, sectorSize)
-				if _, err := r.Read(sectorDataRecords[sectorNumberingMap[i]]); err != nil {
-					return file, err
-				}
-			case 2, 4, 6, 8: // compressed (all bytes are the same)
-				v, err := readByte(r)
-				if err != nil {
-					return file, err
-				}
-				sectorDataRecords[sectorNumberingMap[i]] = make([]byte, sectorSize)
-				fill(sectorDataRecords[sectorNumberingMap[i]], v)
-			}
-		}
+type sliceReader struct {
+	data []byte
+	off  int
+}
 
-		file.Tracks = append(file.Tracks, Track{
-			ModeValue:          modeValue,
-			Cylinder:           cylinder,
-			Head:               head,
-			NumberOfSectors:    numberOfSectors,
-			SectorSize:         sectorSize,
-			SectorNumberingMap: sectorNumberingMap,
-			SectorCylinderMap:  sectorCylinderMap,
-			SectorHeadMap:      sectorHeadMap,
-			SectorDataRecords:  sectorDataRecords,
-		})
-		break
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if r.off >= len(r.data) {
+		return 0, io.EOF
 	}
+	n := copy(p, r.data[r.off:])
+	r.off += n
+	return n, nil
+}
 
-	return file, nil
+func (r *sliceReader) Peek(n int) ([]byte, error) {
+	rest := r.data[r.off:]
+	if len(rest) < n {
+		return rest, io.EOF
+	}
+	return rest[:n], nil
 }
 
 func fill(dst []byte, v byte) {
@@ -148,12 +313,21 @@ func fill(dst []byte, v byte) {
 }
 
 const (
-	sectorCylinderMapMask = (1 << (iota + 6))
-	sectorHeadMapMask
+	sectorHeadMapMask = (1 << (iota + 6))
+	sectorCylinderMapMask
 )
 
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for input ending inside a
+// track.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 func readBytePtr(r io.Reader, dst *byte) error {
-	_, err := r.Read(unsafe.Slice(dst, 1))
+	_, err := io.ReadFull(r, unsafe.Slice(dst, 1))
 
 	return err
 }
@@ -165,21 +339,66 @@ func readByte(r io.Reader) (byte, error) {
 	return v, err
 }
 
+// readStringASCIIEOF reads up to and including the next 0x1A and returns what
+// came before it. Readers that can peek are read in chunks; others are read a
+// byte at a time so that nothing past the terminator is consumed.
 func readStringASCIIEOF(r io.Reader) (string, error) {
-	var str string
+	var str []byte
+
+	if p, ok := peeker(r); ok {
+		var scratch [512]byte
+		for {
+			b, err := p.Peek(len(scratch))
+			if len(b) == 0 {
+				if err == nil {
+					err = io.EOF
+				}
+				return string(str), err
+			}
+
+			n := len(b)
+			i := bytes.IndexByte(b, 0x1A)
+			if i >= 0 {
+				str = append(str, b[:i]...)
+				n = i + 1
+			} else {
+				str = append(str, b...)
+			}
+			if _, err := io.ReadFull(r, scratch[:n]); err != nil {
+				return string(str), err
+			}
+			if i >= 0 {
+				return string(str), nil
+			}
+		}
+	}
 
-	var byt [1]byte
 	for {
-		if _, err := r.Read(byt[:]); err != nil {
-			return str, err
+		b, err := readByte(r)
+		if err != nil {
+			return string(str), err
 		}
 
-		if byt[0] == 0x1A {
-			return str, nil
+		if b == 0x1A {
+			return string(str), nil
 		}
 
-		str += string(byt[0])
+		str = append(str, b)
+	}
+}
+
+// peeker returns r's Peek method, looking through a countingReader, which
+// forwards it.
+func peeker(r io.Reader) (interface{ Peek(int) ([]byte, error) }, bool) {
+	inner := r
+	if c, ok := r.(*countingReader); ok {
+		inner = c.r
+	}
+	if _, ok := inner.(interface{ Peek(int) ([]byte, error) }); !ok {
+		return nil, false
 	}
+	p, ok := r.(interface{ Peek(int) ([]byte, error) })
+	return p, ok
 }
 
 func validateHeader(input Header) error {
@@ -192,15 +411,8 @@ func validateHeader(input Header) error {
 		return errors.New("missing ': ' separator")
 	}
 
-	version := parts[0]
-	if len(version) < 4 || version[1] != '.' || len(version) > 6 {
-		return errors.New("invalid version format")
-	}
-	if _, err := strconv.Atoi(version[:1]); err != nil {
-		return errors.New("invalid major version number")
-	}
-	if _, err := strconv.Atoi(version[2:]); err != nil {
-		return errors.New("invalid minor version number")
+	if _, _, err := parseVersion(parts[0]); err != nil {
+		return err
 	}
 
 	datetime := parts[1]
@@ -230,3 +442,17 @@ func validateHeader(input Header) error {
 
 	return nil
 }
+
+// parseVersion parses the version field of a header, such as "1.18".
+func parseVersion(version string) (major, minor int, err error) {
+	if len(version) < 4 || version[1] != '.' || len(version) > 6 {
+		return 0, 0, errors.New("invalid version format")
+	}
+	if major, err = strconv.Atoi(version[:1]); err != nil {
+		return 0, 0, errors.New("invalid major version number")
+	}
+	if minor, err = strconv.Atoi(version[2:]); err != nil {
+		return 0, 0, errors.New("invalid minor version number")
+	}
+	return major, minor, nil
+}
@@ -1,9 +1,17 @@
 package imd
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 var f, _ = os.Open("disk01.imd")
@@ -14,3 +22,505 @@ func TestX(t *testing.T) {
 
 	fmt.Println(err)
 }
+
+func TestDecodeAll(t *testing.T) {
+	var buf bytes.Buffer
+	for _, comment := range []string{"first", "second"} {
+		err := Encode(&buf, File{
+			Header:  Header("IMD 1.18: 17/10/2014 23:41:07"),
+			Comment: comment,
+			Tracks: []Track{{
+				ModeValue:          5,
+				NumberOfSectors:    1,
+				SectorNumberingMap: []byte{1},
+				SectorDataRecords:  [][]byte{make([]byte, 128)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Comment != "first" || files[1].Comment != "second" {
+		t.Fatalf("DecodeAll returned %d files", len(files))
+	}
+	for _, file := range files {
+		if len(file.Tracks) != 1 {
+			t.Errorf("image %q has %d tracks, want 1", file.Comment, len(file.Tracks))
+		}
+	}
+}
+
+func TestDecodeWarnings(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 0, 0, 0, 0)
+	data = append(data, 5, 1, 0, 1, 0, 1, 9)
+
+	var warnings []string
+	if _, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{Warnings: &warnings, Recover: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) == 0 {
+		t.Fatal("no warnings collected")
+	}
+	if want := "track 0/0 has no sectors"; warnings[0] != want {
+		t.Errorf("warnings[0] = %q, want %q", warnings[0], want)
+	}
+}
+
+func TestDecodeUnknownRecordType(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 0, 0, 2, 0, 1, 2, 2, 0xE5, 9)
+
+	if _, err := Decode(bytes.NewReader(data)); err == nil {
+		t.Fatal("Decode accepted an unknown record type")
+	}
+
+	var warnings []string
+	file, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{Recover: true, Warnings: &warnings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("got %d warnings, want 1", len(warnings))
+	}
+	if len(file.Tracks) != 1 || file.Tracks[0].SectorDataRecords[0] == nil || file.Tracks[0].SectorDataRecords[1] != nil {
+		t.Error("recovered track does not keep the sectors read before the bad record")
+	}
+}
+
+func TestDecodeTruncatedComment(t *testing.T) {
+	data := "IMD 1.18: 17/10/2014 23:41:07\r\nunterminated"
+
+	file, err := Decode(strings.NewReader(data))
+	if !errors.Is(err, ErrTruncatedComment) {
+		t.Fatalf("err = %v, want ErrTruncatedComment", err)
+	}
+	if file.Header.Version() != "1.18" || file.Comment != "\r\nunterminated" {
+		t.Errorf("partial file = %q, %q", file.Header, file.Comment)
+	}
+
+	lazy, err := OpenLazy(strings.NewReader(data), int64(len(data)))
+	if !errors.Is(err, ErrTruncatedComment) || lazy.Comment != "\r\nunterminated" {
+		t.Errorf("OpenLazy = %q, %v", lazy.Comment, err)
+	}
+}
+
+func TestDecodeRecordOffsets(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{RecordOffsets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offsets := file.Offsets()
+	if len(offsets) != len(file.Tracks) {
+		t.Fatalf("got offsets for %d tracks, want %d", len(offsets), len(file.Tracks))
+	}
+	if offsets[0].Track != 0x51 {
+		t.Errorf("track 0 offset = %#x, want 0x51", offsets[0].Track)
+	}
+	for i, off := range offsets[0].Sectors {
+		if data[off] != file.Tracks[0].SectorRecordTypes[i] {
+			t.Errorf("sector %d offset %#x does not point at its record type", i, off)
+		}
+		if !bytes.Equal(data[off+1:off+257], file.Tracks[0].SectorDataRecords[i]) {
+			t.Errorf("sector %d offset %#x does not precede its data", i, off)
+		}
+	}
+}
+
+func TestDecodeSectorSizeOverride(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 0, 0, 2, 7, 1, 2, 1, 'a', 'b', 'c', 2, 'd')
+
+	file, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{SectorSizeOverride: map[byte]int{7: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := file.Tracks[0].SectorDataRecords
+	if string(records[0]) != "abc" || string(records[1]) != "ddd" {
+		t.Errorf("sectors = %q, want abc and ddd", records)
+	}
+}
+
+func TestDecodeInvalidSizeCode(t *testing.T) {
+	for _, code := range []byte{7, 20, 0x24, 56, 63} {
+		data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+		data = append(data, 5, 0, 0, 1, code, 1, RecordCompressed, 0xE5)
+
+		if _, err := Decode(bytes.NewReader(data)); err == nil {
+			t.Errorf("size code %d: Decode accepted it", code)
+		}
+		if _, err := OpenLazy(bytes.NewReader(data), int64(len(data))); err == nil {
+			t.Errorf("size code %d: OpenLazy accepted it", code)
+		}
+	}
+}
+
+func TestDecodeStrictEOF(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 0, 0, 1, 0, 1, 2, 0xE5)
+
+	for _, strict := range []bool{false, true} {
+		if _, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{StrictEOF: strict}); err != nil {
+			t.Errorf("StrictEOF %v: %v", strict, err)
+		}
+	}
+
+	// A second image following the first one.
+	trailing := append(slices.Clone(data), data...)
+	if file, err := Decode(bufio.NewReader(bytes.NewReader(trailing))); err != nil || len(file.Tracks) != 1 {
+		t.Errorf("lenient decode: %d tracks, %v", len(file.Tracks), err)
+	}
+	file, err := DecodeWithOptions(bufio.NewReader(bytes.NewReader(trailing)), DecodeOptions{StrictEOF: true})
+	if !errors.Is(err, ErrTrailingData) {
+		t.Errorf("strict decode err = %v, want ErrTrailingData", err)
+	}
+	if len(file.Tracks) != 1 {
+		t.Error("strict decode dropped the decoded tracks")
+	}
+}
+
+func TestDecodeUnformattedTrack(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 3, 0, 9, 0xFF)
+
+	file, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{StrictEOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := file.Tracks[0]
+	if !track.Unformatted || len(track.SectorNumberingMap) != 0 || track.NumberOfSectors != 9 {
+		t.Errorf("track = %+v", track)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("Encode = %x, want %x", buf.Bytes(), data)
+	}
+
+	lf, err := OpenLazy(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lf.Tracks[0].Unformatted {
+		t.Error("lazy track not marked unformatted")
+	}
+}
+
+func TestDecodeUnknownRecordHandler(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\x1a")
+	data = append(data, 5, 0, 0, 2, 0, 1, 2, 9, 0xAA, 0xBB, 2, 0xE5)
+
+	// A made-up record type 9 carrying a two-byte pattern.
+	handler := func(record byte, r io.Reader) ([]byte, error) {
+		if record != 9 {
+			return nil, fmt.Errorf("unexpected record type %d", record)
+		}
+		var pattern [2]byte
+		if _, err := io.ReadFull(r, pattern[:]); err != nil {
+			return nil, err
+		}
+		return bytes.Repeat(pattern[:], 64), nil
+	}
+	file, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{UnknownRecordHandler: handler, StrictEOF: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := file.Tracks[0]
+	if track.SectorRecordTypes[0] != 9 || !bytes.Equal(track.SectorDataRecords[0], bytes.Repeat([]byte{0xAA, 0xBB}, 64)) {
+		t.Error("handler result not stored")
+	}
+	if !bytes.Equal(track.SectorDataRecords[1], bytes.Repeat([]byte{0xE5}, 128)) {
+		t.Error("sector after the unknown record misread")
+	}
+
+	failing := func(byte, io.Reader) ([]byte, error) { return nil, errors.New("unsupported") }
+	if _, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{UnknownRecordHandler: failing}); err == nil {
+		t.Error("handler error ignored")
+	}
+}
+
+func TestZeroSectorTracks(t *testing.T) {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07\r\n\x1a")
+	for cylinder := byte(0); cylinder < 4; cylinder++ {
+		if cylinder%2 == 1 {
+			data = append(data, 5, cylinder, 0, 0, 2)
+			continue
+		}
+		data = append(data, 5, cylinder, 0, 2, 0, 1, 2, RecordCompressed, 0xE5, RecordNormal)
+		data = append(data, bytes.Repeat([]byte{cylinder, 0xFF}, 64)...)
+	}
+
+	file, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Tracks) != 4 {
+		t.Fatalf("got %d tracks, want 4", len(file.Tracks))
+	}
+	for i, track := range file.Tracks {
+		if empty := i%2 == 1; track.Unformatted != empty || empty && len(track.SectorNumberingMap)+len(track.SectorDataRecords) > 0 {
+			t.Errorf("track %d: %+v", i, track)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("Encode does not reproduce the zero-sector tracks")
+	}
+
+	// A zero-sector track built by hand encodes the same without the flag.
+	buf.Reset()
+	file.Tracks[1].Unformatted = false
+	if err := Encode(&buf, file); err != nil || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("unflagged zero-sector track: %v", err)
+	}
+
+	first := append([]byte("IMD 1.18: 17/10/2014 23:41:07\x1a"), 5, 0, 0, 0, 2)
+	single, err := DecodeWithOptions(bytes.NewReader(first), DecodeOptions{StrictEOF: true})
+	if err != nil || len(single.Tracks) != 1 || !single.Tracks[0].Unformatted {
+		t.Errorf("Decode of a zero-sector track = %+v, %v", single.Tracks, err)
+	}
+}
+
+func TestCommentRoundTrip(t *testing.T) {
+	// Leading metadata, CRLFs, bare CRs and code page 437 bytes that are not valid UTF-8.
+	comment := "\r\nIMD 1.18 quirk line\r\n\r\ncaf\xe9 \x80\xff\xc3\r\r\xc9\xcd\xbb trailing\r\n"
+	file := File{Header: Header("IMD 1.18: 17/10/2014 23:41:07"), Comment: comment}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	encoded := bytes.Clone(buf.Bytes())
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Comment != comment {
+		t.Errorf("comment = %q, want %q", decoded.Comment, comment)
+	}
+	if !bytes.Equal(decoded.CommentBytes(), []byte(comment)) {
+		t.Errorf("CommentBytes() = %q", decoded.CommentBytes())
+	}
+
+	buf.Reset()
+	if err := Encode(&buf, decoded); err != nil || !bytes.Equal(buf.Bytes(), encoded) {
+		t.Error("re-encoded comment differs")
+	}
+}
+
+func commentImage(comment string) []byte {
+	data := []byte("IMD 1.18: 17/10/2014 23:41:07" + comment + "\x1a")
+	return append(data, 5, 0, 0, 1, 0, 1, RecordCompressed, 0xE5)
+}
+
+func TestCommentReaders(t *testing.T) {
+	comment := strings.Repeat("0123456789abcdef", 200) + "\r\n"
+	data := commentImage(comment)
+
+	readers := map[string]func() io.Reader{
+		"plain":    func() io.Reader { return bytes.NewReader(data) },
+		"bufio":    func() io.Reader { return bufio.NewReaderSize(bytes.NewReader(data), 16) },
+		"slice":    func() io.Reader { return &sliceReader{data: data} },
+		"counting": func() io.Reader { return &countingReader{r: &sliceReader{data: data}} },
+	}
+	for name, reader := range readers {
+		file, err := DecodeWithOptions(reader(), DecodeOptions{StrictEOF: true, RecordOffsets: true})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if file.Comment != comment || len(file.Tracks) != 1 || file.Tracks[0].SectorDataRecords[0][0] != 0xE5 {
+			t.Errorf("%s: comment or track misread", name)
+		}
+		if off := file.Offsets()[0].Track; off != int64(len(data)-8) {
+			t.Errorf("%s: track offset %d, want %d", name, off, len(data)-8)
+		}
+	}
+
+	truncated := data[:len(data)-100]
+	for _, r := range []io.Reader{bytes.NewReader(truncated), &sliceReader{data: truncated}} {
+		if _, err := Decode(r); !errors.Is(err, ErrTruncatedComment) {
+			t.Errorf("%T: err = %v, want ErrTruncatedComment", r, err)
+		}
+	}
+}
+
+func benchmarkLongComment(b *testing.B, decode func([]byte) error) {
+	data := commentImage(strings.Repeat("x", 64<<10))
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for range b.N {
+		if err := decode(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLongCommentReader(b *testing.B) {
+	benchmarkLongComment(b, func(data []byte) error {
+		_, err := Decode(bytes.NewReader(data))
+		return err
+	})
+}
+
+func BenchmarkLongCommentBytes(b *testing.B) {
+	benchmarkLongComment(b, func(data []byte) error {
+		_, _, err := DecodeBytes(data)
+		return err
+	})
+}
+
+func TestHeaderValid(t *testing.T) {
+	for h, want := range map[Header]bool{
+		"IMD 1.18: 17/10/2014 23:41:07": true,
+		"IMD 1.17: 01/02/2003 04:05:06": true,
+		"IMD 1.18: 32/10/2014 23:41:07": false,
+		"IMD 1.18:17/10/2014 23:41:07 ": false,
+		"IMD":                           false,
+		"":                              false,
+	} {
+		if h.Valid() != want {
+			t.Errorf("%q.Valid() = %v", h, !want)
+		}
+		if h.Raw() != string(h) {
+			t.Errorf("%q.Raw() = %q", h, h.Raw())
+		}
+	}
+}
+
+func TestDecodeMetrics(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks = file.Tracks[:1]
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := DecodeMetrics{Tracks: make([]TrackMetrics, 3)}
+	if _, err := DecodeWithOptions(&buf, DecodeOptions{Metrics: &metrics}); err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics.Tracks) != 1 {
+		t.Fatalf("got metrics for %d tracks, want 1", len(metrics.Tracks))
+	}
+	got := metrics.Tracks[0]
+	if got.CompressedSectors != 10 || got.UncompressedSectors != 16 {
+		t.Errorf("%d compressed and %d uncompressed sectors, want 10 and 16", got.CompressedSectors, got.UncompressedSectors)
+	}
+	if want := int64(5 + 26 + 10*2 + 16*129); got.Bytes != want {
+		t.Errorf("track is %d bytes, want %d", got.Bytes, want)
+	}
+	if got.Duration <= 0 {
+		t.Error("no duration recorded")
+	}
+}
+
+func TestSetHeaderTime(t *testing.T) {
+	file := File{Header: "IMD 1.17: 17/10/2014 23:41:07"}
+	if err := file.SetHeaderTime(time.Date(1999, 12, 31, 8, 5, 9, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	if file.Header != "IMD 1.17: 31/12/1999 08:05:09" {
+		t.Errorf("header = %q", file.Header)
+	}
+
+	var blank File
+	if err := blank.SetHeaderTime(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil || blank.Header != "IMD 1.18: 01/01/2000 00:00:00" {
+		t.Errorf("header = %q, %v", blank.Header, err)
+	}
+
+	if err := file.SetHeaderTime(time.Date(12000, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("five-digit year accepted")
+	}
+	if file.Header != "IMD 1.17: 31/12/1999 08:05:09" {
+		t.Error("failed SetHeaderTime changed the header")
+	}
+}
+
+func TestDecodeEveryTrack(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := DecodeWithOptions(bytes.NewReader(data), DecodeOptions{RecordOffsets: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Tracks) != 80 {
+		t.Fatalf("decoded %d tracks, want 80", len(file.Tracks))
+	}
+	if last := file.Tracks[79]; last.Cylinder != 39 || last.Head != 1 {
+		t.Errorf("last track is %d/%d, want 39/1", last.Cylinder, last.Head)
+	}
+	if err := file.VerifyRoundTrip(); err != nil {
+		t.Error(err)
+	}
+
+	// Input ending inside a track header or its numbering map is truncated;
+	// ending between tracks is not.
+	last := file.Offsets()[79].Track
+	for _, cut := range []int64{last + 3, last + 6} {
+		if _, err := Decode(bytes.NewReader(data[:cut])); err != io.ErrUnexpectedEOF {
+			t.Errorf("cut at %#x: err = %v, want io.ErrUnexpectedEOF", cut, err)
+		}
+	}
+	if file, err := Decode(bytes.NewReader(data[:last])); err != nil || len(file.Tracks) != 79 {
+		t.Errorf("cut between tracks: %d tracks, %v", len(file.Tracks), err)
+	}
+}
+
+func TestDecodeShortReads(t *testing.T) {
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+		"data err": iotest.DataErrReader,
+		"bufio":    func(r io.Reader) io.Reader { return bufio.NewReaderSize(r, 16) },
+	}
+	for name, wrap := range readers {
+		file, err := Decode(wrap(bytes.NewReader(data)))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(file.Tracks) != len(want.Tracks) || file.Comment != want.Comment {
+			t.Errorf("%s: decoded %d tracks", name, len(file.Tracks))
+			continue
+		}
+		for i := range file.Tracks {
+			if !file.Tracks[i].EqualContent(want.Tracks[i]) {
+				t.Errorf("%s: track %d differs", name, i)
+			}
+		}
+	}
+
+	if _, err := Decode(iotest.HalfReader(bytes.NewReader(data[:len(data)-1]))); err != io.ErrUnexpectedEOF {
+		t.Errorf("image ending inside a sector: err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
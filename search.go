@@ -0,0 +1,43 @@
+package imd
+
+import "bytes"
+
+// SectorMatch locates an occurrence of a pattern within a sector.
+type SectorMatch struct {
+	Cylinder,
+	Head,
+	Sector byte
+
+	// Offset is the position of the match within the sector data.
+	Offset int
+}
+
+// FindBytes returns every occurrence of pattern within the data of f's
+// sectors, in track order and physical sector order. Matches spanning two
+// sectors are not reported.
+func (f File) FindBytes(pattern []byte) []SectorMatch {
+	if len(pattern) == 0 {
+		return nil
+	}
+
+	var matches []SectorMatch
+	for _, t := range f.Tracks {
+		for i, n := range t.SectorNumberingMap {
+			data := t.sectorData(i)
+			for off := 0; ; {
+				j := bytes.Index(data[off:], pattern)
+				if j < 0 {
+					break
+				}
+				matches = append(matches, SectorMatch{
+					Cylinder: t.Cylinder,
+					Head:     t.headNumber(),
+					Sector:   n,
+					Offset:   off + j,
+				})
+				off += j + 1
+			}
+		}
+	}
+	return matches
+}
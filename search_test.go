@@ -0,0 +1,24 @@
+package imd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFindBytes(t *testing.T) {
+	file, _ := sssdImage(t)
+	copy(file.Tracks[2].SectorDataRecords[file.Tracks[2].sectorIndex(7)][10:], "LABELLABEL")
+	copy(file.Tracks[9].SectorDataRecords[0][120:], "LABEL")
+
+	want := []SectorMatch{
+		{Cylinder: 2, Head: 0, Sector: 7, Offset: 10},
+		{Cylinder: 2, Head: 0, Sector: 7, Offset: 15},
+		{Cylinder: 9, Head: 0, Sector: 1, Offset: 120},
+	}
+	if got := file.FindBytes([]byte("LABEL")); !slices.Equal(got, want) {
+		t.Errorf("FindBytes() = %+v, want %+v", got, want)
+	}
+	if got := file.FindBytes(nil); got != nil {
+		t.Errorf("FindBytes(nil) = %+v, want none", got)
+	}
+}
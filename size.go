@@ -0,0 +1,62 @@
+package imd
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+const maxSectorSizeCode = 6
+
+// SectorSizeBytes returns the sector length in bytes for an IMD size code.
+func SectorSizeBytes(code byte) int {
+	return 128 << code
+}
+
+// SectorSizeCode returns the IMD size code for a sector length in bytes. It is
+// the inverse of SectorSizeBytes and only accepts 128, 256, ... 8192.
+func SectorSizeCode(bytes int) (byte, error) {
+	if bytes <= 0 || bytes&(bytes-1) != 0 {
+		return 0, errors.New("sector size is not a power of two")
+	}
+	if bytes < SectorSizeBytes(0) || bytes > SectorSizeBytes(maxSectorSizeCode) {
+		return 0, errors.New("sector size out of range")
+	}
+
+	return byte(bits.TrailingZeros(uint(bytes)) - 7), nil
+}
+
+// Capacity returns the number of data bytes in f's sectors, counting every
+// sector that is not unavailable, including those read with errors.
+func (f File) Capacity() int64 {
+	var n int64
+	for _, t := range f.Tracks {
+		for i := range t.SectorNumberingMap {
+			if t.recordType(i) != RecordUnavailable {
+				n += int64(SectorSizeBytes(t.SectorSize))
+			}
+		}
+	}
+	return n
+}
+
+// CapacityString formats Capacity the way floppy disk capacities are usually
+// quoted, in kilobytes of 1024 bytes and megabytes of 1000 such kilobytes:
+// "360 KB", "1.2 MB" or "1.44 MB".
+func (f File) CapacityString() string {
+	n := f.Capacity()
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d bytes", n)
+	case n < 1000*1024:
+		return trimDecimals(float64(n)/1024) + " KB"
+	}
+	return trimDecimals(float64(n)/(1000*1024)) + " MB"
+}
+
+// trimDecimals formats v with up to two decimals.
+func trimDecimals(v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
@@ -0,0 +1,46 @@
+package imd
+
+import "testing"
+
+func TestSectorSizeCode(t *testing.T) {
+	for code := byte(0); code <= maxSectorSizeCode; code++ {
+		got, err := SectorSizeCode(SectorSizeBytes(code))
+		if err != nil || got != code {
+			t.Errorf("SectorSizeCode(%d) = %d, %v, want %d", SectorSizeBytes(code), got, err, code)
+		}
+	}
+
+	for _, bytes := range []int{0, -128, 64, 100, 384, 16384} {
+		if _, err := SectorSizeCode(bytes); err == nil {
+			t.Errorf("SectorSizeCode(%d) should fail", bytes)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	for format, want := range map[string]string{
+		"360K":       "360 KB",
+		"1.2M":       "1.2 MB",
+		"1.44M":      "1.44 MB",
+		"8inch-SSSD": "250.25 KB",
+	} {
+		g, ok := FormatGeometry(format)
+		if !ok {
+			t.Fatalf("unknown format %s", format)
+		}
+		if got := NewBlankImage(g, 0xE5, "", "").CapacityString(); got != want {
+			t.Errorf("%s: CapacityString() = %q, want %q", format, got, want)
+		}
+	}
+
+	g, _ := FormatGeometry("360K")
+	file := NewBlankImage(g, 0xE5, "", "")
+	file.Tracks[0].SectorRecordTypes[0] = RecordUnavailable
+	file.Tracks[1].SectorRecordTypes[0] = RecordError
+	if got, want := file.Capacity(), int64(360*1024-512); got != want {
+		t.Errorf("Capacity() = %d, want %d", got, want)
+	}
+	if got := (File{}).CapacityString(); got != "0 bytes" {
+		t.Errorf("empty CapacityString() = %q", got)
+	}
+}
@@ -0,0 +1,62 @@
+package imd
+
+// SectorState summarizes the record type of a sector for display.
+type SectorState byte
+
+const (
+	// SectorGood is a sector read without error holding varied data.
+	SectorGood SectorState = iota
+	// SectorCompressed is a sector read without error filled with one byte.
+	SectorCompressed
+	// SectorDeleted is a sector with a deleted data address mark.
+	SectorDeleted
+	// SectorError is a sector read with a data error, deleted or not.
+	SectorError
+	// SectorUnavailable is a sector whose data could not be read at all.
+	SectorUnavailable
+)
+
+func (s SectorState) String() string {
+	switch s {
+	case SectorGood:
+		return "good"
+	case SectorCompressed:
+		return "compressed"
+	case SectorDeleted:
+		return "deleted"
+	case SectorError:
+		return "error"
+	case SectorUnavailable:
+		return "unavailable"
+	}
+	return "unknown"
+}
+
+// SurfaceMap returns the state of every sector of f, track by track in the
+// order of f.Tracks and sector by sector in logical order, for drawing a map
+// of the disk's health. Compression is judged from the sector data, as Encode
+// would write it.
+func (f File) SurfaceMap() [][]SectorState {
+	m := make([][]SectorState, len(f.Tracks))
+	for i, t := range f.Tracks {
+		records := t.MinimalRecordTypes()
+		for _, j := range t.logicalOrder() {
+			m[i] = append(m[i], sectorState(records[j]))
+		}
+	}
+	return m
+}
+
+func sectorState(record byte) SectorState {
+	switch {
+	case record == RecordUnavailable || record > RecordDeletedErrorCompressed:
+		return SectorUnavailable
+	case recordStatus(record) >= RecordError:
+		return SectorError
+	case recordStatus(record) == RecordDeleted:
+		return SectorDeleted
+	case isCompressed(record):
+		return SectorCompressed
+	}
+	return SectorGood
+}
@@ -0,0 +1,36 @@
+package imd
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSurfaceMap(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks = file.Tracks[:2]
+	track := &file.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(1)] = RecordUnavailable
+	track.SectorRecordTypes[track.sectorIndex(2)] = RecordDeletedError
+	track.SectorRecordTypes[track.sectorIndex(3)] = RecordDeleted
+	track.SectorRecordTypes[track.sectorIndex(4)] = RecordCompressed
+
+	m := file.SurfaceMap()
+	if len(m) != 2 || len(m[0]) != 26 || len(m[1]) != 26 {
+		t.Fatalf("map has the wrong shape")
+	}
+
+	want := make([]SectorState, 26)
+	for i := 4; i < 14; i++ {
+		want[i] = SectorCompressed
+	}
+	if !slices.Equal(m[0], want) {
+		t.Errorf("track 0 = %v, want %v", m[0], want)
+	}
+
+	// Sector 4 holds varied data, so its compressed record type is wrong.
+	want = make([]SectorState, 26)
+	want[0], want[1], want[2] = SectorUnavailable, SectorError, SectorDeleted
+	if !slices.Equal(m[1], want) {
+		t.Errorf("track 1 = %v, want %v", m[1], want)
+	}
+}
@@ -0,0 +1,308 @@
+package imd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const headNumberMask = 0x3F
+
+// headNumber returns the physical head with the map-presence flags cleared.
+func (t Track) headNumber() byte {
+	return t.Head & headNumberMask
+}
+
+// recordType returns the record type of the sector at physical index i,
+// deriving one from the sector data when SectorRecordTypes wasn't provided.
+func (t Track) recordType(i int) byte {
+	if i < len(t.SectorRecordTypes) {
+		return t.SectorRecordTypes[i]
+	}
+	if i >= len(t.SectorDataRecords) || t.SectorDataRecords[i] == nil {
+		return RecordUnavailable
+	}
+	return RecordNormal
+}
+
+// SectorsWithForeignAddress returns the physical indices of the sectors whose
+// cylinder or head map entry differs from the track's own cylinder and head,
+// a common trait of copy-protected disks.
+func (t Track) SectorsWithForeignAddress() []int {
+	var foreign []int
+	for i := range t.SectorNumberingMap {
+		if i < len(t.SectorCylinderMap) && t.SectorCylinderMap[i] != t.Cylinder ||
+			i < len(t.SectorHeadMap) && t.SectorHeadMap[i] != t.headNumber() {
+			foreign = append(foreign, i)
+		}
+	}
+	return foreign
+}
+
+// isEmpty reports whether every sector of t is unavailable.
+func (t Track) isEmpty() bool {
+	for i := range t.SectorNumberingMap {
+		if t.recordType(i) != RecordUnavailable {
+			return false
+		}
+	}
+	return true
+}
+
+// sectorIndex returns the physical index of the sector with the given logical
+// number, or -1 if t has no such sector.
+func (t Track) sectorIndex(logical byte) int {
+	for i, n := range t.SectorNumberingMap {
+		if n == logical {
+			return i
+		}
+	}
+	return -1
+}
+
+// AppendSector adds a sector with the given logical number and a copy of data
+// at the end of t as a normal sector, extending the maps present on t. The
+// cylinder and head map entries of the new sector are t's own address. It
+// fails if t already has the sector, is full or unformatted, or if data does
+// not match t's sector size.
+func (t *Track) AppendSector(logicalNumber byte, data []byte) error {
+	switch {
+	case t.Unformatted:
+		return errors.New("track is unformatted")
+	case t.sectorIndex(logicalNumber) >= 0:
+		return fmt.Errorf("sector %d already exists", logicalNumber)
+	case t.NumberOfSectors == 0xFF:
+		return errors.New("track already holds 255 sectors")
+	case len(data) != SectorSizeBytes(t.SectorSize):
+		return fmt.Errorf("sector %d has %d bytes, want %d", logicalNumber, len(data), SectorSizeBytes(t.SectorSize))
+	}
+
+	t.appendSector(logicalNumber, RecordNormal, slices.Clone(data))
+	return nil
+}
+
+// appendSector adds a sector at the end of t, keeping every per-sector slice
+// the same length.
+func (t *Track) appendSector(logical, record byte, data []byte) {
+	t.fillRecords()
+
+	t.SectorNumberingMap = append(t.SectorNumberingMap, logical)
+	if t.SectorCylinderMap != nil {
+		t.SectorCylinderMap = append(t.SectorCylinderMap, t.Cylinder)
+	}
+	if t.SectorHeadMap != nil {
+		t.SectorHeadMap = append(t.SectorHeadMap, t.headNumber())
+	}
+	t.SectorRecordTypes = append(t.SectorRecordTypes, record)
+	t.SectorDataRecords = append(t.SectorDataRecords, data)
+	t.NumberOfSectors++
+}
+
+// fillRecords extends SectorRecordTypes and SectorDataRecords to cover every
+// entry of the numbering map.
+func (t *Track) fillRecords() {
+	for len(t.SectorRecordTypes) < len(t.SectorNumberingMap) {
+		t.SectorRecordTypes = append(t.SectorRecordTypes, t.recordType(len(t.SectorRecordTypes)))
+	}
+	for len(t.SectorDataRecords) < len(t.SectorNumberingMap) {
+		t.SectorDataRecords = append(t.SectorDataRecords, nil)
+	}
+}
+
+// EqualContent reports whether t and o hold the same logical sectors with the
+// same data, regardless of physical order, address or record status.
+func (t Track) EqualContent(o Track) bool {
+	if t.SectorSize != o.SectorSize || len(t.SectorNumberingMap) != len(o.SectorNumberingMap) {
+		return false
+	}
+
+	for i, n := range t.SectorNumberingMap {
+		j := o.sectorIndex(n)
+		if j < 0 {
+			return false
+		}
+		a, b := t.sectorData(i), o.sectorData(j)
+		if (a == nil) != (b == nil) || !bytes.Equal(a, b) {
+			return false
+		}
+	}
+	return true
+}
+
+// sectorData returns the data of the sector at physical index i, or nil if it
+// is unavailable.
+func (t Track) sectorData(i int) []byte {
+	if t.recordType(i) == RecordUnavailable || i >= len(t.SectorDataRecords) {
+		return nil
+	}
+	return t.SectorDataRecords[i]
+}
+
+// ReadSectorInto copies the data of the sector with the given logical number
+// into dst and returns the number of bytes copied. It fails if t has no such
+// sector, if the sector is unavailable or if dst is too small to hold it.
+func (t Track) ReadSectorInto(logicalSector byte, dst []byte) (int, error) {
+	i := t.sectorIndex(logicalSector)
+	if i < 0 {
+		return 0, fmt.Errorf("no sector %d", logicalSector)
+	}
+	data := t.sectorData(i)
+	if data == nil {
+		return 0, fmt.Errorf("sector %d is unavailable", logicalSector)
+	}
+	if len(dst) < len(data) {
+		return 0, fmt.Errorf("sector %d has %d bytes, buffer holds %d", logicalSector, len(data), len(dst))
+	}
+	return copy(dst, data), nil
+}
+
+// FillMissingSectors adds a sector for every number in expected that is not in
+// t's numbering map. The new sectors go at the end of the track and are
+// recorded as unavailable, without data, so RawImage writes
+// RawImageOptions.Fill in their place. Sectors past the 255 a track can hold
+// are not added.
+func (t *Track) FillMissingSectors(expected []byte) {
+	for _, n := range expected {
+		if t.sectorIndex(n) >= 0 || t.NumberOfSectors == 0xFF {
+			continue
+		}
+		t.appendSector(n, RecordUnavailable, nil)
+	}
+}
+
+// MissingSectorNumbers returns the numbers from base through
+// base+expectedCount-1 that do not appear in t's numbering map at all. Unlike
+// an unavailable sector, whose ID was found but whose data could not be read,
+// a missing sector's ID field was never seen, pointing to a damaged or
+// non-standard track. Numbers past 255 are not considered.
+func (t Track) MissingSectorNumbers(expectedCount byte, base byte) []byte {
+	var missing []byte
+	for n := int(base); n < int(base)+int(expectedCount) && n <= 0xFF; n++ {
+		if t.sectorIndex(byte(n)) < 0 {
+			missing = append(missing, byte(n))
+		}
+	}
+	return missing
+}
+
+// IsFullyCompressible reports whether t has at least one sector with data
+// and every such sector is filled with a single byte value, as on formatted
+// but unused tracks.
+func (t Track) IsFullyCompressible() bool {
+	var present bool
+	for i := range t.SectorNumberingMap {
+		data := t.sectorData(i)
+		if data == nil {
+			continue
+		}
+		if !isUniform(data) {
+			return false
+		}
+		present = true
+	}
+	return present
+}
+
+// IsFormattedEmpty reports whether t has at least one sector with data and
+// every such sector is filled with 0xE5 or 0x00, the patterns a format
+// command leaves behind.
+func (t Track) IsFormattedEmpty() bool {
+	if !t.IsFullyCompressible() {
+		return false
+	}
+	for i := range t.SectorNumberingMap {
+		if data := t.sectorData(i); data != nil && data[0] != 0xE5 && data[0] != 0x00 {
+			return false
+		}
+	}
+	return true
+}
+
+// SetLogicalOrder replaces t's numbering map with order, giving the sector at
+// each physical position a new logical number without moving any data. This
+// applies an interleave or skew to a track built from sequential data. order
+// must hold NumberOfSectors distinct numbers.
+func (t *Track) SetLogicalOrder(order []byte) error {
+	if len(order) != int(t.NumberOfSectors) {
+		return fmt.Errorf("order has %d sectors, want %d", len(order), t.NumberOfSectors)
+	}
+	var seen [256]bool
+	for _, n := range order {
+		if seen[n] {
+			return fmt.Errorf("sector %d appears twice", n)
+		}
+		seen[n] = true
+	}
+
+	t.SectorNumberingMap = slices.Clone(order)
+	return nil
+}
+
+// clone returns a deep copy of t.
+func (t Track) clone() Track {
+	t.SectorNumberingMap = slices.Clone(t.SectorNumberingMap)
+	t.SectorCylinderMap = slices.Clone(t.SectorCylinderMap)
+	t.SectorHeadMap = slices.Clone(t.SectorHeadMap)
+	t.SectorRecordTypes = slices.Clone(t.SectorRecordTypes)
+	t.SectorDataRecords = slices.Clone(t.SectorDataRecords)
+	for i, data := range t.SectorDataRecords {
+		t.SectorDataRecords[i] = slices.Clone(data)
+	}
+	return t
+}
+
+// CheckConsistency verifies that t's maps and records agree with each other:
+// the numbering map and the data records hold NumberOfSectors entries, as do
+// the record types and the cylinder and head maps when present, and every
+// sector with data has exactly the length of the size code. An unformatted
+// track must have no maps or records at all.
+func (t Track) CheckConsistency() error {
+	if t.Unformatted {
+		if len(t.SectorNumberingMap)+len(t.SectorCylinderMap)+len(t.SectorHeadMap)+len(t.SectorRecordTypes)+len(t.SectorDataRecords) > 0 {
+			return errors.New("unformatted track has sectors")
+		}
+		return nil
+	}
+
+	n := int(t.NumberOfSectors)
+	for _, m := range []struct {
+		name string
+		len  int
+		set  bool
+	}{
+		{"sector numbering map", len(t.SectorNumberingMap), true},
+		{"sector data records", len(t.SectorDataRecords), true},
+		{"sector record types", len(t.SectorRecordTypes), t.SectorRecordTypes != nil},
+		{"sector cylinder map", len(t.SectorCylinderMap), t.SectorCylinderMap != nil},
+		{"sector head map", len(t.SectorHeadMap), t.SectorHeadMap != nil},
+	} {
+		if m.set && m.len != n {
+			return fmt.Errorf("%s has %d entries, want %d", m.name, m.len, n)
+		}
+	}
+
+	size := SectorSizeBytes(t.SectorSize)
+	for i, data := range t.SectorDataRecords {
+		if data != nil && len(data) != size {
+			return fmt.Errorf("sector %d has %d bytes, want %d", t.SectorNumberingMap[i], len(data), size)
+		}
+	}
+	return nil
+}
+
+// SectorOrder returns the logical sector numbers of t in physical order, the
+// order in which they pass under the head. Index i of the result describes
+// SectorRecordTypes[i] and SectorDataRecords[i].
+func (t Track) SectorOrder() []byte {
+	return slices.Clone(t.SectorNumberingMap)
+}
+
+// LogicalSectors returns the logical sector numbers of t in ascending order,
+// the order in which RawImage and filesystems read them. The result cannot be
+// used to index SectorDataRecords; use SectorOrder for that.
+func (t Track) LogicalSectors() []byte {
+	numbers := slices.Clone(t.SectorNumberingMap)
+	slices.Sort(numbers)
+	return numbers
+}
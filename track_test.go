@@ -0,0 +1,249 @@
+package imd
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestSectorsWithForeignAddress(t *testing.T) {
+	track := Track{
+		Cylinder:           3,
+		Head:               1 | sectorCylinderMapMask | sectorHeadMapMask,
+		NumberOfSectors:    4,
+		SectorNumberingMap: []byte{1, 2, 3, 4},
+		SectorCylinderMap:  []byte{3, 3, 40, 3},
+		SectorHeadMap:      []byte{1, 0, 1, 1},
+	}
+
+	if got, want := track.SectorsWithForeignAddress(), []int{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("SectorsWithForeignAddress() = %v, want %v", got, want)
+	}
+
+	track.SectorCylinderMap, track.SectorHeadMap = nil, nil
+	if got := track.SectorsWithForeignAddress(); got != nil {
+		t.Errorf("SectorsWithForeignAddress() without maps = %v, want none", got)
+	}
+}
+
+func TestFillMissingSectors(t *testing.T) {
+	track := Track{
+		NumberOfSectors:    2,
+		SectorNumberingMap: []byte{3, 1},
+		SectorDataRecords:  [][]byte{make([]byte, 128), make([]byte, 128)},
+	}
+
+	track.FillMissingSectors([]byte{1, 2, 3, 4})
+	if got, want := track.SectorNumberingMap, []byte{3, 1, 2, 4}; !slices.Equal(got, want) {
+		t.Fatalf("numbering map = %v, want %v", got, want)
+	}
+	if track.NumberOfSectors != 4 || track.recordType(2) != RecordUnavailable || track.recordType(0) != RecordNormal {
+		t.Errorf("unexpected track after filling: %+v", track)
+	}
+	if track.SectorDataRecords[2] != nil || track.SectorDataRecords[3] != nil {
+		t.Error("synthesized sectors have data")
+	}
+
+	file := File{Header: Header("IMD 1.18: 17/10/2014 23:41:07"), Tracks: []Track{track}}
+	raw, err := file.RawImage(RawImageOptions{Fill: 0xE5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw[128] != 0xE5 || raw[3*128] != 0xE5 || raw[0] != 0 {
+		t.Error("raw image does not use the fill pattern for synthesized sectors")
+	}
+	if err := file.VerifyRoundTrip(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsFullyCompressible(t *testing.T) {
+	track := Track{
+		NumberOfSectors:    3,
+		SectorNumberingMap: []byte{1, 2, 3},
+		SectorRecordTypes:  []byte{RecordCompressed, RecordUnavailable, RecordNormal},
+		SectorDataRecords:  [][]byte{bytes.Repeat([]byte{0xE5}, 128), nil, bytes.Repeat([]byte{0}, 128)},
+	}
+	if !track.IsFullyCompressible() {
+		t.Error("IsFullyCompressible() = false for uniform sectors")
+	}
+
+	track.SectorDataRecords[2][5] = 1
+	if track.IsFullyCompressible() {
+		t.Error("IsFullyCompressible() = true with a non-uniform sector")
+	}
+
+	empty := Track{NumberOfSectors: 1, SectorNumberingMap: []byte{1}, SectorDataRecords: [][]byte{nil}}
+	if empty.IsFullyCompressible() {
+		t.Error("IsFullyCompressible() = true without any data")
+	}
+}
+
+func TestIsFormattedEmpty(t *testing.T) {
+	track := Track{
+		NumberOfSectors:    3,
+		SectorNumberingMap: []byte{1, 2, 3},
+		SectorRecordTypes:  []byte{RecordCompressed, RecordUnavailable, RecordNormal},
+		SectorDataRecords:  [][]byte{bytes.Repeat([]byte{0xE5}, 128), nil, bytes.Repeat([]byte{0}, 128)},
+	}
+	if !track.IsFormattedEmpty() {
+		t.Error("IsFormattedEmpty() = false for format fill")
+	}
+
+	track.SectorDataRecords[2] = bytes.Repeat([]byte{0xF6}, 128)
+	if track.IsFormattedEmpty() {
+		t.Error("IsFormattedEmpty() = true for an 0xF6 fill")
+	}
+}
+
+func TestSetLogicalOrder(t *testing.T) {
+	file, data := sssdImage(t)
+	track := &file.Tracks[0]
+	track.Deskew()
+	first := track.SectorDataRecords[0]
+
+	order := make([]byte, 26)
+	for i := range order {
+		order[i] = byte((i*2)%26 + 1)
+	}
+	if err := track.SetLogicalOrder(order); err == nil {
+		t.Fatal("SetLogicalOrder accepted duplicate sectors")
+	}
+
+	for i := range order {
+		order[i] = byte((i*3)%26 + 1)
+	}
+	if err := track.SetLogicalOrder(order); err != nil {
+		t.Fatal(err)
+	}
+	if &track.SectorDataRecords[0][0] != &first[0] || !slices.Equal(track.SectorNumberingMap, order) {
+		t.Error("SetLogicalOrder moved data or ignored the order")
+	}
+	if err := track.SetLogicalOrder(order[:25]); err == nil {
+		t.Error("SetLogicalOrder accepted a short order")
+	}
+	if raw, _ := file.RawImage(RawImageOptions{}); bytes.Equal(raw[:26*128], data[:26*128]) {
+		t.Error("renumbering did not change the logical layout")
+	}
+}
+
+func TestSectorOrder(t *testing.T) {
+	file, _ := sssdImage(t)
+	track := file.Tracks[0]
+
+	order := track.SectorOrder()
+	for i, logical := range interleaveOrder(26, 6) {
+		if order[i] != byte(logical+1) {
+			t.Fatalf("SectorOrder = %v", order)
+		}
+	}
+	order[0] = 99
+	if track.SectorNumberingMap[0] == 99 {
+		t.Error("SectorOrder aliases the numbering map")
+	}
+
+	logical := track.LogicalSectors()
+	for i, n := range logical {
+		if n != byte(i+1) {
+			t.Fatalf("LogicalSectors = %v", logical)
+		}
+	}
+}
+
+func TestMissingSectorNumbers(t *testing.T) {
+	track := Track{
+		NumberOfSectors:    4,
+		SectorNumberingMap: []byte{3, 1, 6, 4},
+		SectorRecordTypes:  []byte{RecordNormal, RecordUnavailable, RecordNormal, RecordNormal},
+	}
+
+	if got := track.MissingSectorNumbers(6, 1); !bytes.Equal(got, []byte{2, 5}) {
+		t.Errorf("MissingSectorNumbers(6, 1) = %v", got)
+	}
+	if got := track.MissingSectorNumbers(4, 254); !bytes.Equal(got, []byte{254, 255}) {
+		t.Errorf("MissingSectorNumbers(4, 254) = %v", got)
+	}
+}
+
+func TestReadSectorInto(t *testing.T) {
+	file, data := sssdImage(t)
+	track := file.Tracks[1]
+	track.SectorRecordTypes[track.sectorIndex(7)] = RecordUnavailable
+
+	buf := make([]byte, 200)
+	n, err := track.ReadSectorInto(3, buf)
+	if err != nil || n != 128 {
+		t.Fatalf("ReadSectorInto = %d, %v", n, err)
+	}
+	if want := data[(26+2)*128 : (26+3)*128]; !bytes.Equal(buf[:n], want) {
+		t.Error("wrong sector data")
+	}
+
+	for _, test := range []struct {
+		sector byte
+		size   int
+	}{{27, 128}, {7, 128}, {3, 127}} {
+		if _, err := track.ReadSectorInto(test.sector, make([]byte, test.size)); err == nil {
+			t.Errorf("sector %d into %d bytes: no error", test.sector, test.size)
+		}
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	file, _ := sssdImage(t)
+	if err := file.Tracks[0].CheckConsistency(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (Track{Unformatted: true, NumberOfSectors: 9, SectorSize: unformattedSizeCode}).CheckConsistency(); err != nil {
+		t.Error(err)
+	}
+
+	for name, breakTrack := range map[string]func(*Track){
+		"numbering map": func(t *Track) { t.SectorNumberingMap = t.SectorNumberingMap[1:] },
+		"data records":  func(t *Track) { t.SectorDataRecords = t.SectorDataRecords[1:] },
+		"record types":  func(t *Track) { t.SectorRecordTypes = append(t.SectorRecordTypes, RecordNormal) },
+		"head map":      func(t *Track) { t.SectorHeadMap = []byte{0} },
+		"sector size":   func(t *Track) { t.SectorDataRecords[3] = t.SectorDataRecords[3][:64] },
+		"unformatted":   func(t *Track) { t.Unformatted = true },
+	} {
+		track := file.Tracks[1].clone()
+		breakTrack(&track)
+		if err := track.CheckConsistency(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestAppendSector(t *testing.T) {
+	track := Track{
+		Cylinder:           2,
+		Head:               1 | sectorHeadMapMask,
+		NumberOfSectors:    1,
+		SectorSize:         0,
+		SectorNumberingMap: []byte{1},
+		SectorHeadMap:      []byte{1},
+		SectorDataRecords:  [][]byte{bytes.Repeat([]byte{1}, 128)},
+	}
+
+	data := bytes.Repeat([]byte{2}, 128)
+	if err := track.AppendSector(2, data); err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 0
+	if track.NumberOfSectors != 2 || !bytes.Equal(track.SectorHeadMap, []byte{1, 1}) || track.SectorCylinderMap != nil {
+		t.Errorf("track = %+v", track)
+	}
+	if !bytes.Equal(track.SectorRecordTypes, []byte{RecordNormal, RecordNormal}) || track.SectorDataRecords[1][0] != 2 {
+		t.Error("appended sector not stored as a normal copy")
+	}
+	if err := track.CheckConsistency(); err != nil {
+		t.Error(err)
+	}
+
+	if err := track.AppendSector(2, data); err == nil {
+		t.Error("duplicate sector accepted")
+	}
+	if err := track.AppendSector(3, data[:64]); err == nil {
+		t.Error("short sector accepted")
+	}
+}
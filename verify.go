@@ -0,0 +1,74 @@
+package imd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// VerifyRoundTrip encodes f, decodes the result and reports the first
+// difference from f. Record types only need to agree on availability and
+// deleted/error status, since Encode picks compression on its own.
+func (f File) VerifyRoundTrip() error {
+	var buf bytes.Buffer
+	if err := Encode(&buf, f); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	if decoded.Header != f.Header {
+		return errors.New("header differs")
+	}
+	if decoded.Comment != f.Comment {
+		return errors.New("comment differs")
+	}
+	if len(decoded.Tracks) != len(f.Tracks) {
+		return fmt.Errorf("decoded %d tracks, want %d", len(decoded.Tracks), len(f.Tracks))
+	}
+	for i, t := range f.Tracks {
+		if err := compareTracks(t, decoded.Tracks[i]); err != nil {
+			return fmt.Errorf("track %d/%d: %w", t.Cylinder, t.headNumber(), err)
+		}
+	}
+	return nil
+}
+
+func compareTracks(want, got Track) error {
+	switch {
+	case got.ModeValue != want.ModeValue:
+		return errors.New("mode differs")
+	case got.Cylinder != want.Cylinder || got.headNumber() != want.headNumber():
+		return errors.New("address differs")
+	case got.NumberOfSectors != want.NumberOfSectors:
+		return errors.New("number of sectors differs")
+	case got.SectorSize != want.SectorSize:
+		return errors.New("sector size differs")
+	case !bytes.Equal(got.SectorNumberingMap, want.SectorNumberingMap):
+		return errors.New("sector numbering map differs")
+	case !bytes.Equal(got.SectorCylinderMap, want.SectorCylinderMap):
+		return errors.New("sector cylinder map differs")
+	case !bytes.Equal(got.SectorHeadMap, want.SectorHeadMap):
+		return errors.New("sector head map differs")
+	}
+
+	for i, n := range want.SectorNumberingMap {
+		if recordStatus(got.recordType(i)) != recordStatus(want.recordType(i)) {
+			return fmt.Errorf("sector %d record type differs", n)
+		}
+		if !bytes.Equal(got.sectorData(i), want.sectorData(i)) {
+			return fmt.Errorf("sector %d data differs", n)
+		}
+	}
+	return nil
+}
+
+// recordStatus returns the uncompressed variant of record.
+func recordStatus(record byte) byte {
+	if record == RecordUnavailable {
+		return RecordUnavailable
+	}
+	return (record-1)&^1 + 1
+}
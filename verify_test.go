@@ -0,0 +1,23 @@
+package imd
+
+import "testing"
+
+func TestVerifyRoundTrip(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks = file.Tracks[:1]
+	if err := file.VerifyRoundTrip(); err != nil {
+		t.Fatal(err)
+	}
+
+	file.Tracks[0].SectorDataRecords[0] = file.Tracks[0].SectorDataRecords[0][:64]
+	if err := file.VerifyRoundTrip(); err == nil {
+		t.Error("VerifyRoundTrip accepted a short sector")
+	}
+
+	file, _ = sssdImage(t)
+	file.Tracks = file.Tracks[:1]
+	file.Comment = "a\x1ab"
+	if err := file.VerifyRoundTrip(); err == nil {
+		t.Error("VerifyRoundTrip accepted a comment containing the terminator")
+	}
+}
@@ -0,0 +1,244 @@
+package imd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type EncodeOptions struct {
+	// OmitComment writes only the comment terminator, dropping the comment.
+	OmitComment bool
+
+	// MaxCommentLength, when positive, limits the comment to that many
+	// bytes. A longer comment is cut after the last CRLF that fits, or at the
+	// limit itself if no line ends within it.
+	MaxCommentLength int
+
+	// ForceNormalRecords writes every sector with data as a normal sector,
+	// dropping its deleted and error status, for readers that mishandle those
+	// record types. The status cannot be recovered from the output.
+	ForceNormalRecords bool
+
+	// NoCompression writes every sector with data in full, never as a
+	// compressed record, for readers that lack compression or to get a fixed
+	// size per sector.
+	NoCompression bool
+
+	// KeepRecordTypes writes the record types of SectorRecordTypes as they
+	// are instead of recomputing compression from the data, so that an
+	// image storing uniform sectors uncompressed encodes to the bytes it was
+	// decoded from. A sector recorded as compressed whose data is no longer
+	// uniform is still written in full.
+	KeepRecordTypes bool
+}
+
+func (opts EncodeOptions) comment(comment string) string {
+	if opts.OmitComment {
+		return ""
+	}
+	if opts.MaxCommentLength <= 0 || len(comment) <= opts.MaxCommentLength {
+		return comment
+	}
+	comment = comment[:opts.MaxCommentLength]
+	if i := strings.LastIndex(comment, "\r\n"); i >= 0 {
+		comment = comment[:i+2]
+	}
+	return comment
+}
+
+func Encode(w io.Writer, file File) error {
+	return EncodeWithOptions(w, file, EncodeOptions{})
+}
+
+func EncodeWithOptions(w io.Writer, file File, opts EncodeOptions) error {
+	bw := bufio.NewWriter(w)
+
+	bw.WriteString(string(file.Header))
+	bw.WriteString(opts.comment(file.Comment))
+	bw.WriteByte(0x1A)
+
+	for _, t := range file.Tracks {
+		if err := encodeTrack(bw, t, opts); err != nil {
+			return fmt.Errorf("track %d/%d: %w", t.Cylinder, t.headNumber(), err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// EncodeCanonical writes f in a canonical form, so that images holding the
+// same tracks, sectors and comment encode to the same bytes. Tracks are sorted
+// by cylinder and head, cylinder and head maps that only repeat the track's
+// address are dropped, and records are written in minimal form as Encode
+// does. The header, and with it the timestamp, is kept as is; callers wanting
+// reproducible output across conversions should set a fixed one first.
+func (f File) EncodeCanonical(w io.Writer) error {
+	f.SortTracks()
+	for i, t := range f.Tracks {
+		if isAll(t.SectorCylinderMap, t.Cylinder) {
+			t.SectorCylinderMap = nil
+		}
+		if isAll(t.SectorHeadMap, t.headNumber()) {
+			t.SectorHeadMap = nil
+		}
+		f.Tracks[i] = t
+	}
+	return Encode(w, f)
+}
+
+// isAll reports whether s is non-empty and holds only b.
+func isAll(s []byte, b byte) bool {
+	for _, c := range s {
+		if c != b {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func encodeTrack(w *bufio.Writer, t Track, opts EncodeOptions) error {
+	if t.Unformatted {
+		if t.NumberOfSectors != 0 && t.SectorSize != unformattedSizeCode {
+			return errors.New("unformatted track needs zero sectors or size code 0xFF")
+		}
+		w.Write([]byte{t.ModeValue, t.Cylinder, t.headNumber(), t.NumberOfSectors, t.SectorSize})
+		return nil
+	}
+
+	if len(t.SectorNumberingMap) != int(t.NumberOfSectors) {
+		return errors.New("sector numbering map length does not match number of sectors")
+	}
+	if t.SectorCylinderMap != nil && len(t.SectorCylinderMap) != int(t.NumberOfSectors) {
+		return errors.New("sector cylinder map length does not match number of sectors")
+	}
+	if t.SectorHeadMap != nil && len(t.SectorHeadMap) != int(t.NumberOfSectors) {
+		return errors.New("sector head map length does not match number of sectors")
+	}
+
+	head := t.headNumber()
+	if t.SectorCylinderMap != nil {
+		head |= sectorCylinderMapMask
+	}
+	if t.SectorHeadMap != nil {
+		head |= sectorHeadMapMask
+	}
+
+	w.Write([]byte{t.ModeValue, t.Cylinder, head, t.NumberOfSectors, t.SectorSize})
+	w.Write(t.SectorNumberingMap)
+	w.Write(t.SectorCylinderMap)
+	w.Write(t.SectorHeadMap)
+
+	size := SectorSizeBytes(t.SectorSize)
+	records := t.MinimalRecordTypes()
+	if opts.KeepRecordTypes {
+		records = t.keptRecordTypes()
+	}
+	for i, record := range records {
+		if record > RecordDeletedErrorCompressed {
+			return fmt.Errorf("sector %d has invalid record type %d", t.SectorNumberingMap[i], record)
+		}
+		if opts.ForceNormalRecords && record != RecordUnavailable {
+			record = minimalRecordType(RecordNormal, t.sectorData(i))
+		}
+		if opts.NoCompression && isCompressed(record) {
+			record = recordStatus(record)
+		}
+		w.WriteByte(record)
+		if record == RecordUnavailable {
+			continue
+		}
+
+		data := t.sectorData(i)
+		if len(data) != size {
+			return fmt.Errorf("sector %d has %d bytes, want %d", t.SectorNumberingMap[i], len(data), size)
+		}
+		if isCompressed(record) {
+			w.WriteByte(data[0])
+		} else {
+			w.Write(data)
+		}
+	}
+
+	return nil
+}
+
+// EncodedSize returns the number of bytes Encode writes for f. The result is
+// only meaningful if Encode accepts f.
+func (f File) EncodedSize() int {
+	size := len(f.Header) + len(f.Comment) + 1
+	for _, t := range f.Tracks {
+		size += 5
+		if t.Unformatted {
+			continue
+		}
+		size += len(t.SectorNumberingMap) + len(t.SectorCylinderMap) + len(t.SectorHeadMap)
+		for _, record := range t.MinimalRecordTypes() {
+			size++
+			switch {
+			case record == RecordUnavailable:
+			case isCompressed(record):
+				size++
+			default:
+				size += SectorSizeBytes(t.SectorSize)
+			}
+		}
+	}
+	return size
+}
+
+// MinimalRecordTypes returns the record type Encode writes for each sector
+// of t, in physical order: unavailable sectors stay unavailable, and other
+// sectors keep their deleted and error status but are compressed exactly when
+// their data is uniform. Invalid record types are returned unchanged.
+func (t Track) MinimalRecordTypes() []byte {
+	records := make([]byte, len(t.SectorNumberingMap))
+	for i := range records {
+		record := t.recordType(i)
+		if record != RecordUnavailable && record <= RecordDeletedErrorCompressed {
+			record = minimalRecordType(record, t.sectorData(i))
+		}
+		records[i] = record
+	}
+	return records
+}
+
+// keptRecordTypes returns the record types of t's sectors as stored, with
+// compressed records of non-uniform data replaced by their uncompressed
+// variants.
+func (t Track) keptRecordTypes() []byte {
+	records := make([]byte, len(t.SectorNumberingMap))
+	for i := range records {
+		record := t.recordType(i)
+		if isCompressed(record) && record <= RecordDeletedErrorCompressed && !isUniform(t.sectorData(i)) {
+			record = recordStatus(record)
+		}
+		records[i] = record
+	}
+	return records
+}
+
+// minimalRecordType keeps the deleted and error status of record and picks
+// the compressed variant whenever data is uniform.
+func minimalRecordType(record byte, data []byte) byte {
+	normal := recordStatus(record)
+	if isUniform(data) {
+		return normal + 1
+	}
+	return normal
+}
+
+func isCompressed(record byte) bool {
+	return record != RecordUnavailable && record%2 == 0
+}
+
+func isUniform(data []byte) bool {
+	for _, b := range data {
+		if b != data[0] {
+			return false
+		}
+	}
+	return len(data) > 0
+}
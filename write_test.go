@@ -0,0 +1,348 @@
+package imd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestEncodeReproducesDisk(t *testing.T) {
+	original, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), original) {
+		t.Fatal("encoded image differs from the original")
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	file := File{
+		Header:  Header("IMD 1.18: 17/10/2014 23:41:07"),
+		Comment: "\r\ncomment\r\n",
+		Tracks: []Track{{
+			ModeValue:          5,
+			Cylinder:           7,
+			Head:               1,
+			NumberOfSectors:    4,
+			SectorSize:         1,
+			SectorNumberingMap: []byte{1, 3, 2, 4},
+			SectorHeadMap:      []byte{1, 1, 0, 1},
+			SectorRecordTypes:  []byte{RecordNormal, RecordDeleted, RecordUnavailable, RecordError},
+			SectorDataRecords: [][]byte{
+				bytes.Repeat([]byte{0xE5}, 256),
+				bytes.Repeat([]byte{1, 2}, 128),
+				nil,
+				bytes.Repeat([]byte{3, 4}, 128),
+			},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want, got bytes.Buffer
+	file.Tracks[0].SectorRecordTypes[0] = RecordCompressed
+	file.Dump(&want)
+	decoded.Dump(&got)
+	if want.String() != got.String() {
+		t.Fatalf("round trip mismatch:\n%s\nwant:\n%s", got.String(), want.String())
+	}
+}
+
+func TestCRC32(t *testing.T) {
+	original, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := Decode(bytes.NewReader(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := file.CRC32()
+	file.Comment += "x"
+	if file.CRC32() == before {
+		t.Fatal("CRC32 did not change with the comment")
+	}
+}
+
+// sssdImage returns an 8" single-sided single-density image: 77 cylinders of
+// 26 sectors of 128 bytes, recorded in FM at 500 kbps.
+func sssdImage(t *testing.T) (File, []byte) {
+	t.Helper()
+
+	g := Geometry{Cylinders: 77, Heads: 1, SectorsPerTrack: 26, SectorSize: 128, ModeValue: 0, Interleave: 6}
+	data := make([]byte, 77*26*128)
+	for i := range data {
+		data[i] = byte(i * 7 / 128)
+	}
+	copy(data[128*4:], bytes.Repeat([]byte{0xE5}, 128*10))
+
+	file, err := FromRawImage(data, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Header = Header("IMD 1.18: 17/10/2014 23:41:07")
+	return file, data
+}
+
+func TestEncodeSectorSize128(t *testing.T) {
+	file, data := sssdImage(t)
+	if file.Tracks[0].SectorSize != 0 {
+		t.Fatalf("size code = %d, want 0", file.Tracks[0].SectorSize)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, file); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range decoded.Tracks[0].SectorDataRecords {
+		if len(record) != 128 {
+			t.Fatalf("sector %d has %d bytes, want 128", i, len(record))
+		}
+	}
+	if got := decoded.Tracks[0].SectorRecordTypes; got[0] != RecordNormal || !bytes.Contains(got, []byte{RecordCompressed}) {
+		t.Errorf("record types = %v, want normal and compressed sectors", got)
+	}
+
+	if len(decoded.Tracks) != 77 {
+		t.Fatalf("decoded %d tracks, want 77", len(decoded.Tracks))
+	}
+	raw, err := decoded.RawImage(RawImageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, data) {
+		t.Fatal("SSSD image did not survive the round trip")
+	}
+}
+
+func TestEncodeInvalidRecordType(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks[3].SectorRecordTypes[5] = 9
+
+	err := Encode(io.Discard, file)
+	if err == nil {
+		t.Fatal("Encode accepted record type 9")
+	}
+	want := fmt.Sprintf("track 3/0: sector %d has invalid record type 9", file.Tracks[3].SectorNumberingMap[5])
+	if err.Error() != want {
+		t.Errorf("err = %q, want %q", err, want)
+	}
+}
+
+func TestMinimalRecordTypes(t *testing.T) {
+	uniform := bytes.Repeat([]byte{0xE5}, 128)
+	mixed := bytes.Repeat([]byte{1, 2}, 64)
+	track := Track{
+		NumberOfSectors:    6,
+		SectorNumberingMap: []byte{1, 2, 3, 4, 5, 6},
+		SectorRecordTypes:  []byte{RecordNormal, RecordCompressed, RecordDeleted, RecordErrorCompressed, RecordUnavailable, 12},
+		SectorDataRecords:  [][]byte{uniform, mixed, uniform, mixed, nil, mixed},
+	}
+
+	want := []byte{RecordCompressed, RecordNormal, RecordDeletedCompressed, RecordError, RecordUnavailable, 12}
+	if got := track.MinimalRecordTypes(); !bytes.Equal(got, want) {
+		t.Errorf("MinimalRecordTypes() = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeCommentOptions(t *testing.T) {
+	file := File{
+		Header:  Header("IMD 1.18: 17/10/2014 23:41:07"),
+		Comment: "\r\nfirst line\r\nsecond line\r\n",
+	}
+
+	for _, test := range []struct {
+		opts EncodeOptions
+		want string
+	}{
+		{EncodeOptions{}, file.Comment},
+		{EncodeOptions{OmitComment: true}, ""},
+		{EncodeOptions{MaxCommentLength: 100}, file.Comment},
+		{EncodeOptions{MaxCommentLength: 20}, "\r\nfirst line\r\n"},
+		{EncodeOptions{MaxCommentLength: 1}, "\r"},
+	} {
+		var buf bytes.Buffer
+		if err := EncodeWithOptions(&buf, file, test.opts); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Comment != test.want {
+			t.Errorf("%+v: comment = %q, want %q", test.opts, decoded.Comment, test.want)
+		}
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	sssd, _ := sssdImage(t)
+	sssd.Comment = "\r\ncomment\r\n"
+	sssd.Tracks[1].SectorRecordTypes[4] = RecordUnavailable
+	sssd.Tracks[2].SectorHeadMap = make([]byte, 26)
+	sssd.Tracks = append(sssd.Tracks, Track{Cylinder: 77, NumberOfSectors: 9, SectorSize: unformattedSizeCode, Unformatted: true})
+
+	data, err := os.ReadFile("disk01.imd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []File{sssd, disk} {
+		var buf bytes.Buffer
+		if err := Encode(&buf, file); err != nil {
+			t.Fatal(err)
+		}
+		if size := file.EncodedSize(); size != buf.Len() {
+			t.Errorf("EncodedSize = %d, Encode wrote %d bytes", size, buf.Len())
+		}
+	}
+}
+
+func TestEncodeForceNormalRecords(t *testing.T) {
+	file, _ := sssdImage(t)
+	track := &file.Tracks[0]
+	track.SectorRecordTypes[0] = RecordDeletedError
+	track.SectorRecordTypes[1] = RecordUnavailable
+	e5 := track.sectorIndex(5)
+	track.SectorRecordTypes[e5] = RecordDeleted
+	file.Tracks = file.Tracks[:1]
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, file, EncodeOptions{ForceNormalRecords: true}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := decoded.Tracks[0].SectorRecordTypes
+	if records[0] != RecordNormal || records[1] != RecordUnavailable || records[e5] != RecordCompressed {
+		t.Errorf("record types = %v", records)
+	}
+	if !bytes.Equal(decoded.Tracks[0].SectorDataRecords[0], track.SectorDataRecords[0]) {
+		t.Error("sector data changed")
+	}
+}
+
+func TestEncodeNoCompression(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks = file.Tracks[:1]
+	file.Tracks[0].SectorRecordTypes[file.Tracks[0].sectorIndex(5)] = RecordDeletedError
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, file, EncodeOptions{NoCompression: true}); err != nil {
+		t.Fatal(err)
+	}
+	if want := 0x1D + 1 + 5 + 26 + 26*(1+128); buf.Len() != want {
+		t.Errorf("encoded %d bytes, want %d", buf.Len(), want)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, record := range decoded.Tracks[0].SectorRecordTypes {
+		if isCompressed(record) || record != recordStatus(file.Tracks[0].SectorRecordTypes[i]) {
+			t.Errorf("sector %d has record type %d", decoded.Tracks[0].SectorNumberingMap[i], record)
+		}
+	}
+	if !decoded.Tracks[0].EqualContent(file.Tracks[0]) {
+		t.Error("decoded track differs")
+	}
+}
+
+func TestEncodeCanonical(t *testing.T) {
+	file, _ := sssdImage(t)
+	file.Tracks = file.Tracks[:3]
+
+	other := file
+	other.Tracks = []Track{file.Tracks[2].clone(), file.Tracks[0].clone(), file.Tracks[1].clone()}
+	other.Tracks[0].Head |= sectorHeadMapMask
+	other.Tracks[0].SectorHeadMap = make([]byte, 26)
+	other.Tracks[1].SectorRecordTypes[3] = RecordCompressed
+
+	var a, b bytes.Buffer
+	if err := file.EncodeCanonical(&a); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.EncodeCanonical(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("equivalent images encode differently")
+	}
+	if other.Tracks[0].Cylinder != 2 || other.Tracks[0].SectorHeadMap == nil {
+		t.Error("EncodeCanonical modified the image")
+	}
+
+	other.Tracks[0].SectorHeadMap[0] = 1
+	b.Reset()
+	other.EncodeCanonical(&b)
+	if bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Error("a foreign head map was dropped")
+	}
+}
+
+func TestEncodeKeepRecordTypes(t *testing.T) {
+	uniform := bytes.Repeat([]byte{0xE5}, 128)
+	track := Track{
+		ModeValue:          5,
+		NumberOfSectors:    3,
+		SectorNumberingMap: []byte{1, 2, 3},
+		SectorRecordTypes:  []byte{RecordNormal, RecordDeletedCompressed, RecordCompressed},
+		SectorDataRecords:  [][]byte{uniform, uniform, bytes.Repeat([]byte{1, 2}, 64)},
+	}
+	file := File{Header: Header("IMD 1.18: 17/10/2014 23:41:07"), Tracks: []Track{track}}
+
+	var buf bytes.Buffer
+	if err := EncodeWithOptions(&buf, file, EncodeOptions{KeepRecordTypes: true}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := bytes.Clone(buf.Bytes())
+	if want := 0x1D + 1 + 5 + 3 + (1 + 128) + (1 + 1) + (1 + 128); len(encoded) != want {
+		t.Errorf("encoded %d bytes, want %d", len(encoded), want)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{RecordNormal, RecordDeletedCompressed, RecordNormal}
+	if !bytes.Equal(decoded.Tracks[0].SectorRecordTypes, want) {
+		t.Errorf("record types = %v, want %v", decoded.Tracks[0].SectorRecordTypes, want)
+	}
+
+	buf.Reset()
+	if err := EncodeWithOptions(&buf, decoded, EncodeOptions{KeepRecordTypes: true}); err != nil || !bytes.Equal(buf.Bytes(), encoded) {
+		t.Error("re-encoding with kept record types is not byte-identical")
+	}
+}